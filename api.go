@@ -1,13 +1,10 @@
 package main
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -25,8 +22,8 @@ type APIError struct {
 
 // HeartRateTimeSeries contains heartrate-time data from FitBit's API.
 type HeartRateTimeSeries struct {
-	// Irrelevant struct omitted
-	// ActivitiesHeart []struct {}
+	// ActivitiesHeart has a summary of each day requested, including the user's heart-rate zones.
+	ActivitiesHeart []HeartRateDay `json:"activities-heart"`
 
 	// ActivitiesHeartIntraday has minute-by-minute coverage of a user's heart-rate.
 	ActivitiesHeartIntraday struct {
@@ -36,126 +33,125 @@ type HeartRateTimeSeries struct {
 	} `json:"activities-heart-intraday"`
 }
 
-// Dataset holds the heart bpm at a current time in the format provided by FitBit.
-type Datapoint struct {
-	Time     string    `json:"time"`
-	DateTime time.Time // set by us since fitbit only gives hh:mm, not date in Time
-	Value    int       `json:"value"`
+// SleepLogs contains the sleep logged on a date from FitBit's API.
+type SleepLogs struct {
+	Sleep []SleepLog `json:"sleep"`
 }
 
-// reqInitUserCredentials requests user credentials from FitBit for the first time.
-func reqInitUserCredentials(userAuthCode string, config AppCredentials) (UserCredentials, error) {
-	if userAuthCode == "" {
-		return UserCredentials{}, fmt.Errorf("no user auth code provided")
-	}
-	userCreds, err := reqUserCredentials(config, userAuthCode, "")
-	if err != nil {
-		return UserCredentials{}, fmt.Errorf("error grabbing user tokens and credentials: %w", err)
-	}
-	return userCreds, nil
+// SleepLog is a single period of sleep. Times are in the user's local time, without an offset.
+type SleepLog struct {
+	DateOfSleep   string `json:"dateOfSleep"`
+	StartTime     string `json:"startTime"`
+	EndTime       string `json:"endTime"`
+	Efficiency    int    `json:"efficiency"`
+	IsMainSleep   bool   `json:"isMainSleep"`
+	MinutesAsleep int    `json:"minutesAsleep"`
+	MinutesAwake  int    `json:"minutesAwake"`
+	TimeInBed     int    `json:"timeInBed"`
+	Type          string `json:"type"` // "stages", or "classic" when stages could not be detected
+
+	Levels struct {
+		// Data holds each sleep stage in order. ShortData holds short wakes (under 3 minutes) that interrupt them.
+		Data      []SleepLevel `json:"data"`
+		ShortData []SleepLevel `json:"shortData"`
+	} `json:"levels"`
 }
 
-// reqUserCredentials requests from FitBit the fields in the UserCredentials struct.
-// If requesting a refresh, userAuthCode must be empty and refreshToken filled out.
-// If not requesting a refresh, userAuthCode must be filled and refreshToken empty.
-func reqUserCredentials(appCred AppCredentials, userAuthCode string, refreshToken string) (UserCredentials, error) {
-	vals := url.Values{}
-	vals.Add("clientId", appCred.OAuthClientID)
-	vals.Add("grant_type", "authorization_code")
-	if refreshToken != "" {
-		vals.Set("grant_type", "refresh_token")
-		vals.Set("refresh_token", refreshToken)
-	}
+// SleepLevel is a period spent in one sleep stage e.g., deep, light, rem, wake.
+type SleepLevel struct {
+	DateTime string `json:"dateTime"`
+	Level    string `json:"level"`
+	Seconds  int    `json:"seconds"`
+}
 
-	vals.Add("redirect_uri", "http://localhost:8090")
-	vals.Add("code", userAuthCode)
-	r := strings.NewReader(vals.Encode())
-	req, err := http.NewRequest("POST", "https://api.fitbit.com/oauth2/token", r)
-	if err != nil {
-		return UserCredentials{}, err
-	}
-	authHeader := "Basic " + base64.StdEncoding.EncodeToString([]byte(appCred.OAuthClientID+":"+appCred.ClientSecret))
-	req.Header.Add("Authorization", authHeader)
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+// HeartRateDay summarizes a day of heart-rate data. Intraday responses put the summary at the top level,
+// while date range responses put it in Value.
+type HeartRateDay struct {
+	DateTime         string            `json:"dateTime"`
+	HeartRateZones   []HeartRateZone   `json:"heartRateZones"`
+	RestingHeartRate int               `json:"restingHeartRate"`
+	Value            HeartRateDayValue `json:"value"`
+}
 
-	c := &http.Client{}
-	resp, err := c.Do(req)
-	if err != nil {
-		return UserCredentials{}, err
+// zones returns the day's heart-rate zones wherever the response put them.
+func (d HeartRateDay) zones() []HeartRateZone {
+	if len(d.HeartRateZones) > 0 {
+		return d.HeartRateZones
 	}
+	return d.Value.HeartRateZones
+}
 
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return UserCredentials{}, err
+// restingHeartRate returns the day's resting heart rate wherever the response put it, or 0 if FitBit has none.
+func (d HeartRateDay) restingHeartRate() int {
+	if d.RestingHeartRate > 0 {
+		return d.RestingHeartRate
 	}
+	return d.Value.RestingHeartRate
+}
 
-	if resp.StatusCode != 200 {
-		aErr := APIError{}
-		err = json.Unmarshal(b, &aErr)
-		if err != nil {
-			return UserCredentials{}, err
-		}
+// HeartRateDayValue is the summary of a day in date range responses.
+type HeartRateDayValue struct {
+	HeartRateZones   []HeartRateZone `json:"heartRateZones"`
+	RestingHeartRate int             `json:"restingHeartRate"`
+}
 
-		if !aErr.Success {
-			errArr := make([]string, 0, len(aErr.Errors))
-			for _, s := range aErr.Errors {
-				errArr = append(errArr, s.Message)
-			}
-			return UserCredentials{}, errors.New(strings.Join(errArr, ", "))
-		}
-		return UserCredentials{}, errors.New(resp.Status + " - " + string(b))
+// UnmarshalJSON ignores values that are not objects, since intraday responses put the day's average there as a string.
+func (v *HeartRateDayValue) UnmarshalJSON(b []byte) error {
+	if len(b) == 0 || b[0] != '{' {
+		return nil
 	}
+	type plain HeartRateDayValue
+	return json.Unmarshal(b, (*plain)(v))
+}
 
-	creds := UserCredentials{}
-	err = json.Unmarshal(b, &creds)
-	if err != nil {
-		return UserCredentials{}, err
-	}
-	if !strings.Contains(creds.Scope, "heartrate") {
-		return UserCredentials{}, errors.New("heartrate was not given as a scope permission")
-	}
-	if creds.APIToken == "" {
-		return UserCredentials{}, errors.New("api token empty")
-	}
-	if creds.RefreshToken == "" {
-		return UserCredentials{}, errors.New("refresh token is empty")
-	}
+// HeartRateZone is a range of heart rates FitBit groups exercise intensity by e.g., Fat Burn, Cardio.
+type HeartRateZone struct {
+	Name        string  `json:"name"`
+	Min         int     `json:"min"`
+	Max         int     `json:"max"`
+	Minutes     int     `json:"minutes"`
+	CaloriesOut float64 `json:"caloriesOut"`
+}
 
-	return creds, nil
+// Dataset holds the heart bpm at a current time in the format provided by FitBit.
+type Datapoint struct {
+	Time     string    `json:"time"`
+	DateTime time.Time // set by us since fitbit only gives hh:mm, not date in Time
+	Value    int       `json:"value"`
+	Filled   bool      `json:"-"` // set by us when filling in a missing datapoint
 }
 
-// heartRateTimesSeries returns the heart rate time series from the past four hours in a plottable format.
-// Side Effects: May write to config.json and edit the config argument with a refresh token if token expired.
-func heartRateTimesSeries(config *Config) ([]BannerXY, error) {
-	hrts, err := rawHeartRateTimeSeries(config.UserCredentials, *config)
+// heartRateTimesSeries returns the heart rate time series from the past PlotRange hours in a plottable format,
+// along with the user's heart-rate zones for the most recent day.
+func (c *FitbitClient) heartRateTimesSeries(ctx context.Context, config Config) ([]BannerXY, []HeartRateZone, error) {
+	hrts, err := c.rawHeartRateTimeSeries(ctx, config)
 	if err != nil {
-		if err.Error() == "token must be refreshed" {
-			userCreds, err := reqUserCredentials(config.AppCredentials, "", config.UserCredentials.RefreshToken)
-			if err != nil {
-				return nil, fmt.Errorf("error refreshing tokens and credentials: %w", err)
-			}
-			config.UserCredentials = userCreds
-			err = writeConfigFile(*config)
-			if err != nil {
-				return nil, fmt.Errorf("error writing to config file after getting refresh token: %w", err)
-			}
-			hrts, err = rawHeartRateTimeSeries(config.UserCredentials, *config)
-			if err != nil {
-				return nil, fmt.Errorf("error grabbing heartrate data after token refresh: %w", err)
-			}
-		} else {
-			return nil, fmt.Errorf("error grabbing heartrate data: %w", err)
-		}
+		return nil, nil, fmt.Errorf("error grabbing heartrate data: %w", err)
 	}
 
 	xy := make([]BannerXY, 0, len(hrts.ActivitiesHeartIntraday.Dataset))
 	for _, pt := range hrts.ActivitiesHeartIntraday.Dataset {
 		xy = append(xy, BannerXY{
-			X: pt.DateTime,
-			Y: pt.Value,
+			X:      pt.DateTime,
+			Y:      pt.Value,
+			Filled: pt.Filled,
 		})
 	}
-	return xy, nil
+	for i := len(xy) - 1; i >= 0; i-- {
+		if !xy[i].Filled {
+			c.Telemetry.observeHeartRate(xy[i].Y, xy[i].X)
+			break
+		}
+	}
+	var zones []HeartRateZone
+	if n := len(hrts.ActivitiesHeart); n > 0 {
+		day := hrts.ActivitiesHeart[n-1]
+		zones = day.zones()
+		if date, err := time.ParseInLocation("2006-01-02", day.DateTime, config.location()); err == nil && day.restingHeartRate() > 0 {
+			c.Telemetry.observeRestingHeartRate(day.restingHeartRate(), date)
+		}
+	}
+	return xy, zones, nil
 }
 
 // dateHourMin returns a time.Time as YYYY-MM-DD and HH.
@@ -177,96 +173,185 @@ func prependZero(i int) string {
 	return "0" + str
 }
 
-// rawHeartRateTimeSeries returns heartrate-time data from FitBit.
-func rawHeartRateTimeSeries(userCreds UserCredentials, config Config) (HeartRateTimeSeries, error) {
-	hourRange := config.PlotRange
-	tRange := time.Hour * time.Duration(hourRange)
-	loc := time.FixedZone("zone", config.Timezone*3600)
-	now := time.Now().UTC().In(loc)
-	endDate, endHr := dateHourMin(now)
-	startDate, startHr := dateHourMin(now.Add(-tRange))
-	u := `https://api.fitbit.com/1/user/%s/activities/heart/date/%s/%s/1min/time/%s/%s.json`
-	uri := fmt.Sprintf(u, userCreds.UserID, startDate, endDate, startHr, endHr)
-
-	r, err := http.NewRequest("GET", uri, nil)
-	if err != nil {
-		return HeartRateTimeSeries{}, err
-	}
-	r.Header.Add("Authorization", "Bearer "+userCreds.APIToken)
-	c := http.Client{}
-	resp, err := c.Do(r)
-	if err != nil {
-		return HeartRateTimeSeries{}, err
-	}
-	if resp.StatusCode == 401 {
-		b, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return HeartRateTimeSeries{}, err
+// maxIntradayRange is the longest window FitBit returns intraday data for in a single request.
+const maxIntradayRange = 24 * time.Hour
+
+// storeStartSlack is how far after the start of the plot range the first datapoint stored may be for the store
+// to be trusted to hold the range up to its last datapoint. It's the longest interval between datapoints.
+const storeStartSlack = 15 * time.Minute
+
+// rawHeartRateTimeSeries returns heartrate-time data from FitBit for the past PlotRange hours, at the configured detail level.
+// With a store holding the start of the range, only data from the last datapoint stored onwards is fetched,
+// and the rest is read from the store.
+// Datapoints fetched for the first time are passed to the client's sinks.
+func (c *FitbitClient) rawHeartRateTimeSeries(ctx context.Context, config Config) (HeartRateTimeSeries, error) {
+	end := c.clock().In(config.location())
+	start := end.Add(-time.Hour * time.Duration(config.PlotRange))
+	detailLevel, interval := config.detailLevel()
+
+	// The whole range is fetched when the store only holds part of it, e.g. after plot_range grew.
+	fetchStart := start
+	if c.Store != nil {
+		first, hasStart := c.Store.First(MetricHeartRate, start)
+		last, ok := c.Store.Last(MetricHeartRate)
+		if hasStart && first.Sub(start) <= storeStartSlack && ok && last.Before(end) {
+			fetchStart = last.In(start.Location())
 		}
-		apiErr := APIError{}
-		err = json.Unmarshal(b, &apiErr)
-		if err != nil {
+	}
+
+	ts := HeartRateTimeSeries{}
+	dataset := make([]Datapoint, 0)
+	for _, w := range intradayWindows(fetchStart, end) {
+		startDate, startHr := dateHourMin(w.start)
+		endDate, endHr := dateHourMin(w.end)
+		u := `/1/user/%s/activities/heart/date/%s/%s/%s/time/%s/%s.json`
+		path := fmt.Sprintf(u, c.credentials().UserID, startDate, endDate, detailLevel, startHr, endHr)
+
+		wts := HeartRateTimeSeries{}
+		if err := c.get(ctx, path, &wts); err != nil {
 			return HeartRateTimeSeries{}, err
 		}
-		if strings.Contains(apiErr.Errors[0].Message, "Access token expired") {
-			return HeartRateTimeSeries{}, fmt.Errorf("token must be refreshed")
-		}
-		return HeartRateTimeSeries{}, fmt.Errorf(apiErr.Errors[0].Message)
+		dataset = append(dataset, datePoints(wts.ActivitiesHeartIntraday.Dataset, w.start, interval)...)
+		ts.ActivitiesHeart = append(ts.ActivitiesHeart, wts.ActivitiesHeart...)
+		ts.ActivitiesHeartIntraday.DatasetInterval = wts.ActivitiesHeartIntraday.DatasetInterval
+		ts.ActivitiesHeartIntraday.DatasetType = wts.ActivitiesHeartIntraday.DatasetType
 	}
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+
+	if err := c.record(MetricHeartRate, dataset); err != nil {
 		return HeartRateTimeSeries{}, err
 	}
-	ts := HeartRateTimeSeries{}
-	err = json.Unmarshal(b, &ts)
-	if err != nil {
-		return HeartRateTimeSeries{}, err
+	if c.Store != nil {
+		dataset = c.Store.Range(MetricHeartRate, start, end)
+	}
+
+	continuousDataset := fillGaps(dataset, interval, config.Gaps)
+	ts.ActivitiesHeartIntraday.Dataset = continuousDataset
+
+	return ts, nil
+}
+
+// timeWindow is a span of time requested from FitBit in one call.
+type timeWindow struct {
+	start, end time.Time
+}
+
+// intradayWindows splits start to end into windows FitBit can serve in one request each.
+// Windows up to 24 hours are requested whole; longer ones are split into one window per day.
+func intradayWindows(start, end time.Time) []timeWindow {
+	if end.Sub(start) <= maxIntradayRange {
+		return []timeWindow{{start, end}}
+	}
+	windows := make([]timeWindow, 0, int(end.Sub(start)/maxIntradayRange)+2)
+	for cur := start; cur.Before(end); {
+		nextMidnight := time.Date(cur.Year(), cur.Month(), cur.Day()+1, 0, 0, 0, 0, cur.Location())
+		windowEnd := nextMidnight.Add(-time.Second)
+		if windowEnd.After(end) {
+			windowEnd = end
+		}
+		windows = append(windows, timeWindow{cur, windowEnd})
+		cur = nextMidnight
 	}
+	return windows
+}
+
+// datePoints sets DateTime on each datapoint, since FitBit only gives the time of day.
+// The dataset is walked from the start of the window it was requested for: the date advances
+// whenever the time of day would otherwise go backwards, or fall more than one interval between
+// datapoints before the window's start (FitBit's first bucket may begin before it, e.g. 10:00 at 15min
+// detail for a window starting 10:07).
+func datePoints(dataset []Datapoint, windowStart time.Time, interval time.Duration) []Datapoint {
+	loc := windowStart.Location()
+	windowStart = windowStart.Truncate(time.Minute)
+	day := time.Date(windowStart.Year(), windowStart.Month(), windowStart.Day(), 0, 0, 0, 0, loc)
+	prev := windowStart.Add(-interval)
 
-	dataset := make([]Datapoint, 0, len(ts.ActivitiesHeartIntraday.Dataset))
-	for _, entry := range ts.ActivitiesHeartIntraday.Dataset {
-		sp := strings.Split(entry.Time, ":")
-		hr, _ := strconv.Atoi(sp[0])
-		min, _ := strconv.Atoi(sp[1])
-
-		today := now
-		yesterday := now.Add(time.Hour * -24)
-		actualDay := today
-		if startDate != endDate { // determining actual date since fitbit does not include date in Datapoint.Time
-			if hr > hourRange {
-				actualDay = yesterday
+	dated := make([]Datapoint, 0, len(dataset))
+	for _, entry := range dataset {
+		hr, min, sec, err := parseTimeOfDay(entry.Time)
+		if err != nil {
+			continue
+		}
+		t := time.Date(day.Year(), day.Month(), day.Day(), hr, min, sec, 0, loc)
+		if t.Before(prev) {
+			if alt := t.Add(time.Hour); !alt.Before(prev) && alt.Hour() == hr {
+				t = alt // the repeated hour when DST ends
+			} else {
+				day = day.AddDate(0, 0, 1)
+				t = time.Date(day.Year(), day.Month(), day.Day(), hr, min, sec, 0, loc)
 			}
 		}
-		dataset = append(dataset, Datapoint{
+		dated = append(dated, Datapoint{
 			Time:     entry.Time,
-			DateTime: time.Date(actualDay.Year(), actualDay.Month(), actualDay.Day(), hr, min, 0, 0, time.UTC),
+			DateTime: t,
 			Value:    entry.Value,
 		})
+		prev = t
 	}
+	return dated
+}
 
-	continuousDataset := fillInGaps(dataset, 60)
-	ts.ActivitiesHeartIntraday.Dataset = continuousDataset
-
-	return ts, nil
+// parseTimeOfDay parses FitBit's HH:MM:SS or HH:MM time of day.
+func parseTimeOfDay(s string) (hr, min, sec int, err error) {
+	sp := strings.Split(s, ":")
+	if len(sp) < 2 || len(sp) > 3 {
+		return 0, 0, 0, fmt.Errorf("invalid time of day: %q", s)
+	}
+	nums := [3]int{}
+	for i := range sp {
+		if nums[i], err = strconv.Atoi(sp[i]); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid time of day: %q", s)
+		}
+	}
+	return nums[0], nums[1], nums[2], nil
 }
 
-// fillInGaps fills in gaps in the dataset with the previous datapoint, so ticks can be generated at hour marks.
+// fillInGaps fills in gaps in the dataset with the previous datapoint, so there is a datapoint every gapAmt seconds.
+// This is the forward_fill gap policy. The dataset is sorted by time first, keeping the last of datapoints sharing
+// a timestamp. data is never modified, and apart from sorting unsorted input, it runs in linear time.
 func fillInGaps(data []Datapoint, gapAmt int64) []Datapoint {
 	if len(data) == 0 {
 		return []Datapoint{}
 	}
-	start := data[0].DateTime.Unix() + gapAmt
-	for i, validTime := 1, start;
-		i < len(data);
-		i, validTime = i+1, validTime+gapAmt {
-			if data[i].DateTime.Unix() != validTime {
-				newEntry := Datapoint{
+	sorted := sortDedup(data)
+	if gapAmt <= 0 {
+		return sorted
+	}
+
+	first, last := sorted[0].DateTime.Unix(), sorted[len(sorted)-1].DateTime.Unix()
+	filled := make([]Datapoint, 0, (last-first)/gapAmt+int64(len(sorted)))
+	for i, pt := range sorted {
+		if i > 0 {
+			prev := sorted[i-1]
+			for validTime := prev.DateTime.Unix() + gapAmt; validTime < pt.DateTime.Unix(); validTime += gapAmt {
+				filled = append(filled, Datapoint{
 					Time:     "",
-					DateTime: time.Unix(validTime, 0),
-					Value:    data[i-1].Value,
-				}
-				data = append(data[:i], append([]Datapoint{newEntry}, data[i:]...)...)
+					DateTime: time.Unix(validTime, 0).In(prev.DateTime.Location()),
+					Value:    prev.Value,
+					Filled:   true,
+				})
 			}
+		}
+		filled = append(filled, pt)
+	}
+	return filled
+}
+
+// sortDedup returns a copy of data sorted by time to the second, keeping the last of datapoints sharing a timestamp.
+func sortDedup(data []Datapoint) []Datapoint {
+	sorted := make([]Datapoint, len(data))
+	copy(sorted, data)
+	less := func(i, j int) bool { return sorted[i].DateTime.Unix() < sorted[j].DateTime.Unix() }
+	if !sort.SliceIsSorted(sorted, less) {
+		sort.SliceStable(sorted, less)
+	}
+
+	deduped := sorted[:0]
+	for _, pt := range sorted {
+		if n := len(deduped); n > 0 && deduped[n-1].DateTime.Unix() == pt.DateTime.Unix() {
+			deduped[n-1] = pt
+			continue
+		}
+		deduped = append(deduped, pt)
 	}
-	return data
+	return deduped
 }
@@ -2,9 +2,11 @@ package main
 
 import (
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"testing/quick"
 	"time"
 )
 
@@ -74,10 +76,25 @@ func Test_fillInGaps(t *testing.T) {
 		}, 60}},
 		{"real data", args{parseMapStr(`{14:39:00 2021-03-06 14:39:00 +0000 UTC 63} {14:40:00 2021-03-06 14:40:00 +0000 UTC 69} {14:41:00 2021-03-06 14:41:00 +0000 UTC 70} {14:42:00 2021-03-06 14:42:00 +0000 UTC 68} {14:43:00 2021-03-06 14:43:00 +0000 UTC 66} {14:44:00 2021-03-06 14:44:00 +0000 UTC 73} {14:45:00 2021-03-06 14:45:00 +0000 UTC 67} {14:46:00 2021-03-06 14:46:00 +0000 UTC 66} {14:47:00 2021-03-06 14:47:00 +0000 UTC 58} {14:48:00 2021-03-06 14:48:00 +0000 UTC 62} {14:49:00 2021-03-06 14:49:00 +0000 UTC 64} {14:50:00 2021-03-06 14:50:00 +0000 UTC 67} {14:51:00 2021-03-06 14:51:00 +0000 UTC 61} {14:52:00 2021-03-06 14:52:00 +0000 UTC 67} {14:53:00 2021-03-06 14:53:00 +0000 UTC 62} {14:54:00 2021-03-06 14:54:00 +0000 UTC 64} {14:55:00 2021-03-06 14:55:00 +0000 UTC 64} {14:56:00 2021-03-06 14:56:00 +0000 UTC 74} {14:57:00 2021-03-06 14:57:00 +0000 UTC 72} {14:58:00 2021-03-06 14:58:00 +0000 UTC 63} {14:59:00 2021-03-06 14:59:00 +0000 UTC 58} {15:00:00 2021-03-06 15:00:00 +0000 UTC 59} {15:01:00 2021-03-06 15:01:00 +0000 UTC 61} {15:02:00 2021-03-06 15:02:00 +0000 UTC 61} {15:03:00 2021-03-06 15:03:00 +0000 UTC 61} {15:04:00 2021-03-06 15:04:00 +0000 UTC 67} {15:05:00 2021-03-06 15:05:00 +0000 UTC 70} {15:06:00 2021-03-06 15:06:00 +0000 UTC 70} {15:07:00 2021-03-06 15:07:00 +0000 UTC 74} {15:08:00 2021-03-06 15:08:00 +0000 UTC 90} {15:09:00 2021-03-06 15:09:00 +0000 UTC 92} {15:10:00 2021-03-06 15:10:00 +0000 UTC 91} {15:11:00 2021-03-06 15:11:00 +0000 UTC 89} {15:12:00 2021-03-06 15:12:00 +0000 UTC 95} {15:13:00 2021-03-06 15:13:00 +0000 UTC 77} {15:14:00 2021-03-06 15:14:00 +0000 UTC 75} {15:15:00 2021-03-06 15:15:00 +0000 UTC 75} {15:16:00 2021-03-06 15:16:00 +0000 UTC 74} {15:17:00 2021-03-06 15:17:00 +0000 UTC 75} {15:18:00 2021-03-06 15:18:00 +0000 UTC 77} {15:19:00 2021-03-06 15:19:00 +0000 UTC 79} {15:20:00 2021-03-06 15:20:00 +0000 UTC 81} {15:21:00 2021-03-06 15:21:00 +0000 UTC 81} { 2021-03-06 15:22:00 +0000 UTC 81} {15:23:00 2021-03-06 15:23:00 +0000 UTC 77} {15:24:00 2021-03-06 15:24:00 +0000 UTC 77} {15:25:00 2021-03-06 15:25:00 +0000 UTC 77} {15:26:00 2021-03-06 15:26:00 +0000 UTC 77} {15:27:00 2021-03-06 15:27:00 +0000 UTC 77} {15:28:00 2021-03-06 15:28:00 +0000 UTC 81} {15:29:00 2021-03-06 15:29:00 +0000 UTC 81} {15:30:00 2021-03-06 15:30:00 +0000 UTC 81} {15:31:00 2021-03-06 15:31:00 +0000 UTC 83} {15:32:00 2021-03-06 15:32:00 +0000 UTC 86} {15:33:00 2021-03-06 15:33:00 +0000 UTC 90} {15:34:00 2021-03-06 15:34:00 +0000 UTC 92} {15:35:00 2021-03-06 15:35:00 +0000 UTC 87} {15:36:00 2021-03-06 15:36:00 +0000 UTC 87} {15:37:00 2021-03-06 15:37:00 +0000 UTC 89} {15:38:00 2021-03-06 15:38:00 +0000 UTC 81} {15:39:00 2021-03-06 15:39:00 +0000 UTC 76} {15:40:00 2021-03-06 15:40:00 +0000 UTC 75} {15:41:00 2021-03-06 15:41:00 +0000 UTC 77} {15:42:00 2021-03-06 15:42:00 +0000 UTC 76} {15:43:00 2021-03-06 15:43:00 +0000 UTC 79} {15:44:00 2021-03-06 15:44:00 +0000 UTC 81} {15:45:00 2021-03-06 15:45:00 +0000 UTC 81} {15:46:00 2021-03-06 15:46:00 +0000 UTC 81} {15:47:00 2021-03-06 15:47:00 +0000 UTC 94} {15:48:00 2021-03-06 15:48:00 +0000 UTC 119} {15:49:00 2021-03-06 15:49:00 +0000 UTC 131} {15:50:00 2021-03-06 15:50:00 +0000 UTC 135} { 2021-03-06 15:51:00 +0000 UTC 135} {15:52:00 2021-03-06 15:52:00 +0000 UTC 130} { 2021-03-06 15:53:00 +0000 UTC 130} {15:54:00 2021-03-06 15:54:00 +0000 UTC 123} {15:55:00 2021-03-06 15:55:00 +0000 UTC 118} {15:56:00 2021-03-06 15:56:00 +0000 UTC 112} {15:57:00 2021-03-06 15:57:00 +0000 UTC 125} {15:58:00 2021-03-06 15:58:00 +0000 UTC 128} {15:59:00 2021-03-06 15:59:00 +0000 UTC 166} {16:00:00 2021-03-06 16:00:00 +0000 UTC 171} {16:01:00 2021-03-06 16:01:00 +0000 UTC 171} { 2021-03-06 16:02:00 +0000 UTC 171} {16:03:00 2021-03-06 16:03:00 +0000 UTC 175} { 2021-03-06 16:04:00 +0000 UTC 175} {16:05:00 2021-03-06 16:05:00 +0000 UTC 159} {16:06:00 2021-03-06 16:06:00 +0000 UTC 159} {16:07:00 2021-03-06 16:07:00 +0000 UTC 156} { 2021-03-06 16:08:00 +0000 UTC 156} {16:09:00 2021-03-06 16:09:00 +0000 UTC 139} {16:10:00 2021-03-06 16:10:00 +0000 UTC 140} {16:11:00 2021-03-06 16:11:00 +0000 UTC 98} {16:12:00 2021-03-06 16:12:00 +0000 UTC 89} {16:13:00 2021-03-06 16:13:00 +0000 UTC 84} {16:14:00 2021-03-06 16:14:00 +0000 UTC 82} {16:15:00 2021-03-06 16:15:00 +0000 UTC 89} {16:16:00 2021-03-06 16:16:00 +0000 UTC 86} {16:17:00 2021-03-06 16:17:00 +0000 UTC 86} {16:18:00 2021-03-06 16:18:00 +0000 UTC 86} {16:19:00 2021-03-06 16:19:00 +0000 UTC 83} {16:20:00 2021-03-06 16:20:00 +0000 UTC 84} {16:21:00 2021-03-06 16:21:00 +0000 UTC 85} {16:22:00 2021-03-06 16:22:00 +0000 UTC 88} {16:23:00 2021-03-06 16:23:00 +0000 UTC 83} {16:24:00 2021-03-06 16:24:00 +0000 UTC 85} {16:25:00 2021-03-06 16:25:00 +0000 UTC 86} {16:26:00 2021-03-06 16:26:00 +0000 UTC 86} {16:27:00 2021-03-06 16:27:00 +0000 UTC 85} {16:28:00 2021-03-06 16:28:00 +0000 UTC 89} {16:29:00 2021-03-06 16:29:00 +0000 UTC 89} {16:30:00 2021-03-06 16:30:00 +0000 UTC 90} {16:31:00 2021-03-06 16:31:00 +0000 UTC 86} {16:32:00 2021-03-06 16:32:00 +0000 UTC 86} {16:33:00 2021-03-06 16:33:00 +0000 UTC 86} {16:34:00 2021-03-06 16:34:00 +0000 UTC 86} {16:35:00 2021-03-06 16:35:00 +0000 UTC 86} {16:36:00 2021-03-06 16:36:00 +0000 UTC 86} {16:37:00 2021-03-06 16:37:00 +0000 UTC 87} {16:38:00 2021-03-06 16:38:00 +0000 UTC 83} {16:39:00 2021-03-06 16:39:00 +0000 UTC 85} {16:40:00 2021-03-06 16:40:00 +0000 UTC 86} {16:41:00 2021-03-06 16:41:00 +0000 UTC 83} {16:42:00 2021-03-06 16:42:00 +0000 UTC 82} {16:43:00 2021-03-06 16:43:00 +0000 UTC 82} {16:44:00 2021-03-06 16:44:00 +0000 UTC 82} { 2021-03-06 16:45:00 +0000 UTC 82} {16:46:00 2021-03-06 16:46:00 +0000 UTC 88} {16:47:00 2021-03-06 16:47:00 +0000 UTC 85} {16:48:00 2021-03-06 16:48:00 +0000 UTC 87} {16:49:00 2021-03-06 16:49:00 +0000 UTC 85} {16:50:00 2021-03-06 16:50:00 +0000 UTC 85} { 2021-03-06 16:51:00 +0000 UTC 85} {17:06:00 2021-03-06 17:06:00 +0000 UTC 81} {17:07:00 2021-03-06 17:07:00 +0000 UTC 81} {17:08:00 2021-03-06 17:08:00 +0000 UTC 83} {17:09:00 2021-03-06 17:09:00 +0000 UTC 84} {17:10:00 2021-03-06 17:10:00 +0000 UTC 86} {17:11:00 2021-03-06 17:11:00 +0000 UTC 84} {17:12:00 2021-03-06 17:12:00 +0000 UTC 84} {17:13:00 2021-03-06 17:13:00 +0000 UTC 80} {17:14:00 2021-03-06 17:14:00 +0000 UTC 82} {17:15:00 2021-03-06 17:15:00 +0000 UTC 83} {17:16:00 2021-03-06 17:16:00 +0000 UTC 83} {17:17:00 2021-03-06 17:17:00 +0000 UTC 80} {17:18:00 2021-03-06 17:18:00 +0000 UTC 83} {17:19:00 2021-03-06 17:19:00 +0000 UTC 81} {17:20:00 2021-03-06 17:20:00 +0000 UTC 82} {17:21:00 2021-03-06 17:21:00 +0000 UTC 85} {17:22:00 2021-03-06 17:22:00 +0000 UTC 89} {17:23:00 2021-03-06 17:23:00 +0000 UTC 87} {17:24:00 2021-03-06 17:24:00 +0000 UTC 92} {17:25:00 2021-03-06 17:25:00 +0000 UTC 87} {17:26:00 2021-03-06 17:26:00 +0000 UTC 87} {17:27:00 2021-03-06 17:27:00 +0000 UTC 89} {17:28:00 2021-03-06 17:28:00 +0000 UTC 83} {17:29:00 2021-03-06 17:29:00 +0000 UTC 81} {17:30:00 2021-03-06 17:30:00 +0000 UTC 83} {17:31:00 2021-03-06 17:31:00 +0000 UTC 82} {17:32:00 2021-03-06 17:32:00 +0000 UTC 81} {17:33:00 2021-03-06 17:33:00 +0000 UTC 82} {17:34:00 2021-03-06 17:34:00 +0000 UTC 80} {17:35:00 2021-03-06 17:35:00 +0000 UTC 87} {17:36:00 2021-03-06 17:36:00 +0000 UTC 79} {17:37:00 2021-03-06 17:37:00 +0000 UTC 83} {17:38:00 2021-03-06 17:38:00 +0000 UTC 84} {17:39:00 2021-03-06 17:39:00 +0000 UTC 85} {17:40:00 2021-03-06 17:40:00 +0000 UTC 82} {17:41:00 2021-03-06 17:41:00 +0000 UTC 84} {17:42:00 2021-03-06 17:42:00 +0000 UTC 85} {17:43:00 2021-03-06 17:43:00 +0000 UTC 82} {17:44:00 2021-03-06 17:44:00 +0000 UTC 85} {17:45:00 2021-03-06 17:45:00 +0000 UTC 87} {17:46:00 2021-03-06 17:46:00 +0000 UTC 86} {17:47:00 2021-03-06 17:47:00 +0000 UTC 82} {17:48:00 2021-03-06 17:48:00 +0000 UTC 82} {17:49:00 2021-03-06 17:49:00 +0000 UTC 86} {17:50:00 2021-03-06 17:50:00 +0000 UTC 79} {17:51:00 2021-03-06 17:51:00 +0000 UTC 81} {17:52:00 2021-03-06 17:52:00 +0000 UTC 83} {17:53:00 2021-03-06 17:53:00 +0000 UTC 81} {17:54:00 2021-03-06 17:54:00 +0000 UTC 81} {17:55:00 2021-03-06 17:55:00 +0000 UTC 78} {17:56:00 2021-03-06 17:56:00 +0000 UTC 81} {17:57:00 2021-03-06 17:57:00 +0000 UTC 81} {17:58:00 2021-03-06 17:58:00 +0000 UTC 84} {17:59:00 2021-03-06 17:59:00 +0000 UTC 85} {18:00:00 2021-03-06 18:00:00 +0000 UTC 85} {18:01:00 2021-03-06 18:01:00 +0000 UTC 86} {18:02:00 2021-03-06 18:02:00 +0000 UTC 89} {18:03:00 2021-03-06 18:03:00 +0000 UTC 87} {18:04:00 2021-03-06 18:04:00 +0000 UTC 88} {18:05:00 2021-03-06 18:05:00 +0000 UTC 91} {18:06:00 2021-03-06 18:06:00 +0000 UTC 86} {18:07:00 2021-03-06 18:07:00 +0000 UTC 86} {18:08:00 2021-03-06 18:08:00 +0000 UTC 84} {18:09:00 2021-03-06 18:09:00 +0000 UTC 83} {18:10:00 2021-03-06 18:10:00 +0000 UTC 85} {18:11:00 2021-03-06 18:11:00 +0000 UTC 83} {18:12:00 2021-03-06 18:12:00 +0000 UTC 83} {18:13:00 2021-03-06 18:13:00 +0000 UTC 83} {18:14:00 2021-03-06 18:14:00 +0000 UTC 84} {18:15:00 2021-03-06 18:15:00 +0000 UTC 87} {18:16:00 2021-03-06 18:16:00 +0000 UTC 84} {18:17:00 2021-03-06 18:17:00 +0000 UTC 84} {18:18:00 2021-03-06 18:18:00 +0000 UTC 79} {18:19:00 2021-03-06 18:19:00 +0000 UTC 83} {18:20:00 2021-03-06 18:20:00 +0000 UTC 81} {18:21:00 2021-03-06 18:21:00 +0000 UTC 77} {18:22:00 2021-03-06 18:22:00 +0000 UTC 77} {18:23:00 2021-03-06 18:23:00 +0000 UTC 79} {18:24:00 2021-03-06 18:24:00 +0000 UTC 81} {18:25:00 2021-03-06 18:25:00 +0000 UTC 78} {18:26:00 2021-03-06 18:26:00 +0000 UTC 81}`),
 			60}},
+		{"1sec", args{[]Datapoint{
+			{Time: "", DateTime: time.Unix(10, 0), Value: 90},
+			{Time: "", DateTime: time.Unix(11, 0), Value: 91},
+			// 4 seconds omitted
+			{Time: "", DateTime: time.Unix(16, 0), Value: 95},
+		}, 1}},
+		{"5min", args{[]Datapoint{
+			{Time: "", DateTime: time.Unix(0, 0), Value: 60},
+			// 300 omitted
+			{Time: "", DateTime: time.Unix(600, 0), Value: 70},
+			{Time: "", DateTime: time.Unix(900, 0), Value: 70},
+		}, 300}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			fixed := fillInGaps(tt.args.data, tt.args.gapAmt)
+			if gap := fixed[1].DateTime.Unix() - fixed[0].DateTime.Unix(); gap != tt.args.gapAmt {
+				t.Errorf("got gaps of %ds, want %ds", gap, tt.args.gapAmt)
+			}
 			err := equalGapsCheck(fixed)
 			if err != nil {
 				t.Errorf(err.Error())
@@ -86,6 +103,112 @@ func Test_fillInGaps(t *testing.T) {
 	}
 }
 
+// randomDataset builds a dataset from offsets (in seconds) after a fixed time, in the order given,
+// so it can be unsorted and contain duplicates.
+func randomDataset(offsets []uint16, values []uint8) []Datapoint {
+	start := time.Date(2021, 3, 6, 0, 0, 0, 0, time.UTC)
+	data := make([]Datapoint, len(offsets))
+	for i, off := range offsets {
+		v := 0
+		if i < len(values) {
+			v = int(values[i])
+		}
+		data[i] = Datapoint{DateTime: start.Add(time.Duration(off) * time.Second), Value: v}
+	}
+	return data
+}
+
+func Test_fillInGaps_properties(t *testing.T) {
+	const gapAmt = 60
+	property := func(offsets []uint16, values []uint8) bool {
+		data := randomDataset(offsets, values)
+		original := make([]Datapoint, len(data))
+		copy(original, data)
+		filled := fillInGaps(data, gapAmt)
+
+		if !reflect.DeepEqual(data, original) {
+			t.Log("input was modified")
+			return false
+		}
+		lastValue := make(map[int64]int) // the last value given for each timestamp
+		for _, pt := range data {
+			lastValue[pt.DateTime.Unix()] = pt.Value
+		}
+		measured := 0
+		for i, pt := range filled {
+			if i > 0 {
+				gap := pt.DateTime.Unix() - filled[i-1].DateTime.Unix()
+				if gap <= 0 || gap > gapAmt {
+					t.Logf("gap of %ds at %d", gap, i)
+					return false
+				}
+				if pt.Filled && pt.Value != filled[i-1].Value {
+					t.Logf("filled in %d at %d, want the previous value %d", pt.Value, i, filled[i-1].Value)
+					return false
+				}
+			}
+			if !pt.Filled {
+				measured++
+				if v, ok := lastValue[pt.DateTime.Unix()]; !ok || v != pt.Value {
+					t.Logf("measured datapoint %v at %d is not the last given for its time", pt, i)
+					return false
+				}
+			}
+		}
+		if measured != len(lastValue) {
+			t.Logf("got %d measured datapoints, want one per distinct time (%d)", measured, len(lastValue))
+			return false
+		}
+		return reflect.DeepEqual(fillInGaps(filled, gapAmt), filled) // filling is idempotent
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_fillInGaps_duplicatesAndUnsorted(t *testing.T) {
+	data := []Datapoint{
+		{DateTime: time.Unix(180, 0), Value: 3},
+		{DateTime: time.Unix(60, 0), Value: 1},
+		{DateTime: time.Unix(60, 0), Value: 2}, // replaces the datapoint before it
+	}
+	filled := fillInGaps(data, 60)
+	got := make([]string, 0, len(filled))
+	for _, pt := range filled {
+		got = append(got, fmt.Sprintf("%d:%d", pt.DateTime.Unix(), pt.Value))
+	}
+	if want := "60:2 120:2 180:3"; strings.Join(got, " ") != want {
+		t.Errorf("got %s, want %s", strings.Join(got, " "), want)
+	}
+	if data[0].Value != 3 || data[1].Value != 1 {
+		t.Error("input was modified")
+	}
+}
+
+func BenchmarkFillInGaps(b *testing.B) {
+	start := time.Date(2021, 3, 6, 0, 0, 0, 0, time.UTC)
+	benchmarks := []struct {
+		name   string
+		every  time.Duration // between datapoints
+		span   time.Duration
+		gapAmt int64
+	}{
+		{"1min over 7 days", 2 * time.Minute, 7 * 24 * time.Hour, 60},
+		{"1sec over 24 hours", 5 * time.Second, 24 * time.Hour, 1},
+	}
+	for _, bm := range benchmarks {
+		data := make([]Datapoint, 0, int(bm.span/bm.every))
+		for t := start; t.Before(start.Add(bm.span)); t = t.Add(bm.every) {
+			data = append(data, Datapoint{DateTime: t, Value: 60})
+		}
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				fillInGaps(data, bm.gapAmt)
+			}
+		})
+	}
+}
+
 func Test_dateHour(t *testing.T) {
 	type args struct {
 		t time.Time
@@ -114,3 +237,97 @@ func Test_dateHour(t *testing.T) {
 	}
 }
 
+
+func Test_datePoints(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pts := func(times ...string) []Datapoint {
+		dataset := make([]Datapoint, 0, len(times))
+		for _, tim := range times {
+			dataset = append(dataset, Datapoint{Time: tim})
+		}
+		return dataset
+	}
+	tests := []struct {
+		name        string
+		dataset     []Datapoint
+		windowStart time.Time
+		interval    time.Duration
+		want        []time.Time
+	}{
+		{"same day", pts("10:00:00", "10:01:00"), time.Date(2021, 3, 6, 9, 30, 0, 0, chicago), time.Minute, []time.Time{
+			time.Date(2021, 3, 6, 10, 0, 0, 0, chicago),
+			time.Date(2021, 3, 6, 10, 1, 0, 0, chicago),
+		}},
+		{"spans midnight", pts("23:58:00", "23:59:00", "00:00:00", "00:01:00"), time.Date(2021, 3, 6, 22, 0, 0, 0, chicago), time.Minute, []time.Time{
+			time.Date(2021, 3, 6, 23, 58, 0, 0, chicago),
+			time.Date(2021, 3, 6, 23, 59, 0, 0, chicago),
+			time.Date(2021, 3, 7, 0, 0, 0, 0, chicago),
+			time.Date(2021, 3, 7, 0, 1, 0, 0, chicago),
+		}},
+		{"no data before midnight", pts("00:05:00", "01:00:00"), time.Date(2021, 3, 6, 22, 0, 0, 0, chicago), time.Minute, []time.Time{
+			time.Date(2021, 3, 7, 0, 5, 0, 0, chicago),
+			time.Date(2021, 3, 7, 1, 0, 0, 0, chicago),
+		}},
+		{"early hour with long range", pts("20:00:00", "01:00:00"), time.Date(2021, 3, 6, 2, 0, 0, 0, chicago), time.Minute, []time.Time{
+			time.Date(2021, 3, 6, 20, 0, 0, 0, chicago),
+			time.Date(2021, 3, 7, 1, 0, 0, 0, chicago),
+		}},
+		{"DST ends", pts("01:58:00", "01:59:00", "01:00:00", "01:01:00"), time.Date(2021, 11, 7, 1, 0, 0, 0, chicago), time.Minute, []time.Time{
+			time.Date(2021, 11, 7, 6, 58, 0, 0, time.UTC),
+			time.Date(2021, 11, 7, 6, 59, 0, 0, time.UTC),
+			time.Date(2021, 11, 7, 7, 0, 0, 0, time.UTC),
+			time.Date(2021, 11, 7, 7, 1, 0, 0, time.UTC),
+		}},
+		{"bucket before window start", pts("10:00:00", "10:15:00"), time.Date(2021, 3, 6, 10, 7, 0, 0, chicago), 15 * time.Minute, []time.Time{
+			time.Date(2021, 3, 6, 10, 0, 0, 0, chicago),
+			time.Date(2021, 3, 6, 10, 15, 0, 0, chicago),
+		}},
+		{"window starting mid-minute", pts("10:07:00", "10:07:01"), time.Date(2021, 3, 6, 10, 7, 30, 0, chicago), time.Second, []time.Time{
+			time.Date(2021, 3, 6, 10, 7, 0, 0, chicago),
+			time.Date(2021, 3, 6, 10, 7, 1, 0, chicago),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := datePoints(tt.dataset, tt.windowStart, tt.interval)
+			if len(got) != len(tt.want) {
+				t.Fatalf("datePoints() returned %d points, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !got[i].DateTime.Equal(tt.want[i]) {
+					t.Errorf("datePoints()[%d] = %v, want %v", i, got[i].DateTime, tt.want[i])
+				}
+				if got[i].DateTime.Location() != chicago {
+					t.Errorf("datePoints()[%d] in %v, want %v", i, got[i].DateTime.Location(), chicago)
+				}
+			}
+		})
+	}
+}
+
+func Test_intradayWindows(t *testing.T) {
+	start := time.Date(2021, 3, 6, 18, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		end  time.Time
+		want []timeWindow
+	}{
+		{"4 hours", start.Add(4 * time.Hour), []timeWindow{{start, start.Add(4 * time.Hour)}}},
+		{"24 hours", start.Add(24 * time.Hour), []timeWindow{{start, start.Add(24 * time.Hour)}}},
+		{"48 hours", start.Add(48 * time.Hour), []timeWindow{
+			{start, time.Date(2021, 3, 6, 23, 59, 59, 0, time.UTC)},
+			{time.Date(2021, 3, 7, 0, 0, 0, 0, time.UTC), time.Date(2021, 3, 7, 23, 59, 59, 0, time.UTC)},
+			{time.Date(2021, 3, 8, 0, 0, 0, 0, time.UTC), start.Add(48 * time.Hour)},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := intradayWindows(start, tt.end); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("intradayWindows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
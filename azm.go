@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"strings"
+	"time"
+)
+
+// AZMConfig configures the /azm.svg banner.
+type AZMConfig struct {
+	// Title is the title at the top of the banner. Defaults to "My Active Zone Minutes This Week".
+	Title string `json:"title"`
+
+	// Goal is the number of Active Zone Minutes to earn each week. Defaults to 150.
+	Goal int `json:"goal"`
+
+	// WeekStart is the day weeks start on: monday or sunday. Defaults to monday.
+	WeekStart string `json:"week_start"`
+}
+
+func (ac AZMConfig) withDefaults() AZMConfig {
+	if ac.Title == "" {
+		ac.Title = "My Active Zone Minutes This Week"
+	}
+	if ac.Goal <= 0 {
+		ac.Goal = 150
+	}
+	if !strings.EqualFold(ac.WeekStart, "sunday") {
+		ac.WeekStart = "monday"
+	}
+	return ac
+}
+
+// weekStart returns local midnight of the first day of the week t falls in.
+func (ac AZMConfig) weekStart(t time.Time) time.Time {
+	first := time.Monday
+	if strings.EqualFold(ac.WeekStart, "sunday") {
+		first = time.Sunday
+	}
+	daysIn := (int(t.Weekday()) - int(first) + 7) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-daysIn, 0, 0, 0, 0, t.Location())
+}
+
+// ActiveZoneMinutesSeries contains the Active Zone Minutes earned each day from FitBit's API.
+// Minutes in the cardio and peak zones earn double.
+type ActiveZoneMinutesSeries struct {
+	ActivitiesActiveZoneMinutes []struct {
+		DateTime string            `json:"dateTime"`
+		Value    ActiveZoneMinutes `json:"value"`
+	} `json:"activities-active-zone-minutes"`
+}
+
+// ActiveZoneMinutes is the Active Zone Minutes earned in a day, in total and by zone.
+type ActiveZoneMinutes struct {
+	Total   int `json:"activeZoneMinutes"`
+	FatBurn int `json:"fatBurnActiveZoneMinutes"`
+	Cardio  int `json:"cardioActiveZoneMinutes"`
+	Peak    int `json:"peakActiveZoneMinutes"`
+}
+
+// weeklyAZM returns the Active Zone Minutes earned each day of the week so far, starting on the week's first day.
+// Days without data are zero.
+func (c *FitbitClient) weeklyAZM(ctx context.Context, config Config) ([]ActiveZoneMinutes, error) {
+	if err := c.requireScopes("activity"); err != nil {
+		return nil, err
+	}
+	now := c.clock().In(config.location())
+	start := config.AZM.withDefaults().weekStart(now)
+	startDate, _ := dateHourMin(start)
+	endDate, _ := dateHourMin(now)
+	path := fmt.Sprintf(`/1/user/%s/activities/active-zone-minutes/date/%s/%s.json`, c.credentials().UserID, startDate, endDate)
+
+	series := ActiveZoneMinutesSeries{}
+	if err := c.get(ctx, path, &series); err != nil {
+		return nil, fmt.Errorf("error grabbing active zone minutes data: %w", err)
+	}
+	week := make([]ActiveZoneMinutes, 7)
+	for _, day := range series.ActivitiesActiveZoneMinutes {
+		date, err := time.ParseInLocation("2006-01-02", day.DateTime, start.Location())
+		if err != nil {
+			continue
+		}
+		if i := int(date.Sub(start).Hours()/24 + 0.5); i >= 0 && i < len(week) {
+			week[i] = day.Value
+		}
+	}
+	return week, nil
+}
+
+func updateAZMSVG(ctx context.Context, client *FitbitClient, c Config) (string, error) {
+	week, err := client.weeklyAZM(ctx, c)
+	if err != nil {
+		return "", bannerError("grabbing active zone minutes", err)
+	}
+	banner, err := genAZMBanner(week, c)
+	if err != nil {
+		return "", bannerError("generating active zone minutes banner", err)
+	}
+	return banner, nil
+}
+
+// genAZMBanner charts the minutes earned in each zone every day of the week, stacked,
+// with a ring on the left showing progress toward the weekly goal.
+func genAZMBanner(week []ActiveZoneMinutes, config Config) (string, error) {
+	azmConfig := config.AZM.withDefaults()
+	total := weekTotal(week)
+	fatBurn, cardio, peak := make(plotter.Values, len(week)), make(plotter.Values, len(week)), make(plotter.Values, len(week))
+	for i, day := range week {
+		fatBurn[i], cardio[i], peak[i] = float64(day.FatBurn), float64(day.Cardio), float64(day.Peak)
+	}
+
+	thirdWidth := config.BannerWidth / 3 // ring takes up 1/3rd, chart 2/3rd
+	plotWidth := thirdWidth * 2
+
+	p := newPlot(config)
+	barWidth := vg.Length(plotWidth) / vg.Length(len(week)+3)
+	var below *plotter.BarChart
+	for _, stack := range []struct {
+		zone   string
+		values plotter.Values
+	}{
+		{"Fat Burn", fatBurn},
+		{"Cardio", cardio},
+		{"Peak", peak},
+	} {
+		bars, err := plotter.NewBarChart(stack.values, barWidth)
+		if err != nil {
+			return "", err
+		}
+		bars.Color = RGBAFromString(config.Theme.azmColor(stack.zone))
+		bars.LineStyle.Width = 0
+		if below != nil {
+			bars.StackOn(below)
+		}
+		p.Add(bars)
+		below = bars
+	}
+	p.NominalX(weekdayLabels(azmConfig.weekStart(time.Now().In(config.location())))...)
+	p.Y.Min = 0
+
+	tData := newTemplate(config, azmConfig.Title)
+	tData.Plot = renderPlot(p, plotWidth, config)
+	tData.Icon = genRing(float64(total)/float64(azmConfig.Goal), thirdWidth, config.BannerHeight, config.Theme.Heart, config.Theme.Axes)
+	tData.Value = fmt.Sprintf("%d/%d", total, azmConfig.Goal)
+	tData.ValueSize = 20
+	tData.ValueColor = config.Theme.CurrentBPM
+	tData.Caption = "Zone Minutes"
+	return execTemplate(tData)
+}
+
+// weekdayLabels returns the short names of the seven days starting at start e.g., Mon, Tue.
+func weekdayLabels(start time.Time) []string {
+	labels := make([]string, 7)
+	for i := range labels {
+		labels[i] = start.AddDate(0, 0, i).Format("Mon")
+	}
+	return labels
+}
+
+// weekTotal returns the active zone minutes earned over week, counted toward the weekly goal.
+func weekTotal(week []ActiveZoneMinutes) int {
+	total := 0
+	for _, day := range week {
+		total += day.Total
+	}
+	return total
+}
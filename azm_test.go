@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAZMConfig_weekStart(t *testing.T) {
+	thu := time.Date(2021, 10, 28, 15, 4, 0, 0, time.UTC)
+	tests := []struct {
+		weekStart string
+		t         time.Time
+		want      time.Time
+	}{
+		{"", thu, time.Date(2021, 10, 25, 0, 0, 0, 0, time.UTC)},
+		{"monday", time.Date(2021, 10, 25, 0, 0, 0, 0, time.UTC), time.Date(2021, 10, 25, 0, 0, 0, 0, time.UTC)},
+		{"sunday", thu, time.Date(2021, 10, 24, 0, 0, 0, 0, time.UTC)},
+		{"Sunday", time.Date(2021, 10, 24, 23, 0, 0, 0, time.UTC), time.Date(2021, 10, 24, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := (AZMConfig{WeekStart: tt.weekStart}).weekStart(tt.t); !got.Equal(tt.want) {
+			t.Errorf("weekStart(%q, %v) = %v, want %v", tt.weekStart, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestFitbitClient_weeklyAZM(t *testing.T) {
+	now := time.Date(2021, 3, 7, 23, 59, 0, 0, time.UTC) // the last minute of a week starting Monday
+	start := (AZMConfig{}).weekStart(now)
+	first, _ := dateHourMin(start)
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/activities/active-zone-minutes/date/"+first+"/") {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		second, _ := dateHourMin(start.AddDate(0, 0, 1))
+		fmt.Fprintf(w, `{"activities-active-zone-minutes":[
+			{"dateTime":"%s","value":{"activeZoneMinutes":32,"fatBurnActiveZoneMinutes":12,"cardioActiveZoneMinutes":20}},
+			{"dateTime":"%s","value":{"activeZoneMinutes":13,"fatBurnActiveZoneMinutes":3,"peakActiveZoneMinutes":10}}]}`, first, second)
+	}))
+	c.UserCredentials.Scope = "heartrate activity"
+	c.now = func() time.Time { return now }
+
+	week, err := c.weeklyAZM(context.Background(), Config{TimezoneName: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(week) != 7 || week[0] != (ActiveZoneMinutes{Total: 32, FatBurn: 12, Cardio: 20}) || week[1] != (ActiveZoneMinutes{Total: 13, FatBurn: 3, Peak: 10}) {
+		t.Errorf("got %v, want the week's first two days filled in", week)
+	}
+	if got := weekTotal(week); got != 45 {
+		t.Errorf("weekTotal() = %d, want 45", got)
+	}
+}
+
+func Test_genAZMBanner(t *testing.T) {
+	week := make([]ActiveZoneMinutes, 7)
+	week[0] = ActiveZoneMinutes{Total: 40, FatBurn: 10, Cardio: 20, Peak: 10}
+	week[1] = ActiveZoneMinutes{Total: 5, FatBurn: 5}
+	banner, err := genAZMBanner(week, testBannerConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{">45/150<", "Zone Minutes", "Mon", "Sun"} {
+		if !strings.Contains(banner, want) {
+			t.Errorf("banner is missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// unstoredBanners are the banners whose data isn't kept in the store, so backfilling would not fill them in.
+// They always fetch what they show from FitBit.
+var unstoredBanners = []string{"sleep", "spo2", "hrv", "azm"}
+
+// backfill fetches a day of data at a time into the client's store, walking back from today to from.
+// Days a previous backfill finished are skipped, so an interrupted backfill resumes where it left off.
+// When FitBit's rate limit is hit, it waits for the limit to reset and carries on.
+func backfill(ctx context.Context, c *FitbitClient, config Config, from time.Time) error {
+	if c.Store == nil {
+		return fmt.Errorf("no store to backfill, set store in config.json")
+	}
+	loc := config.location()
+	now := c.clock().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+	if from.After(today) {
+		return fmt.Errorf("backfill start date %s is in the future", from.Format("2006-01-02"))
+	}
+
+	steps := config.bannerEnabled("steps")
+	if err := c.requireScopes("activity"); steps && err != nil {
+		log.Print("Not backfilling steps: ", err.Error())
+		steps = false
+	}
+	skipped := make([]string, 0)
+	for _, name := range unstoredBanners {
+		if config.bannerEnabled(name) {
+			skipped = append(skipped, name)
+		}
+	}
+	if len(skipped) > 0 {
+		log.Printf("Not backfilling the %s banners: only heart rate, resting heart rate and steps are kept in the store", strings.Join(skipped, ", "))
+	}
+
+	for day := today; !day.Before(from); {
+		date, _ := dateHourMin(day)
+		if !day.Equal(today) && len(c.Store.Range(MetricBackfilled, day, day)) > 0 {
+			day = day.AddDate(0, 0, -1)
+			continue
+		}
+
+		n, err := backfillDay(ctx, c, config, day, steps)
+		var rlErr *RateLimitError
+		if errors.As(err, &rlErr) {
+			wait := rlErr.RetryAfter
+			if wait <= 0 {
+				wait = rateLimitFallbackWait
+			}
+			log.Printf("Waiting for the FitBit rate limit to reset before backfilling %s in %s", date, wait.Round(time.Second))
+			t := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			case <-t.C:
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error backfilling %s: %w", date, err)
+		}
+
+		// Today isn't over, so it's fetched again by the next backfill.
+		if !day.Equal(today) {
+			if _, err := c.Store.Add(MetricBackfilled, []Datapoint{{DateTime: day, Value: 1}}); err != nil {
+				return err
+			}
+		}
+		log.Printf("Backfilled %s: %d heart-rate datapoints", date, n)
+		day = day.AddDate(0, 0, -1)
+	}
+	return nil
+}
+
+// backfillDay fetches the intraday heart rate, resting heart rate and, if steps is true, steps of the day
+// starting at midnight day into the client's store. It returns the number of heart-rate datapoints fetched.
+func backfillDay(ctx context.Context, c *FitbitClient, config Config, day time.Time, steps bool) (int, error) {
+	date, _ := dateHourMin(day)
+	detailLevel, interval := config.detailLevel()
+	userID := c.credentials().UserID
+
+	hrts := HeartRateTimeSeries{}
+	if err := c.get(ctx, fmt.Sprintf(`/1/user/%s/activities/heart/date/%s/1d/%s.json`, userID, date, detailLevel), &hrts); err != nil {
+		return 0, fmt.Errorf("error grabbing heartrate data: %w", err)
+	}
+	heartRate := datePoints(hrts.ActivitiesHeartIntraday.Dataset, day, interval)
+	if err := c.record(MetricHeartRate, heartRate); err != nil {
+		return 0, err
+	}
+	for _, d := range hrts.ActivitiesHeart {
+		if resting := d.restingHeartRate(); resting > 0 {
+			if err := c.record(MetricRestingHeartRate, []Datapoint{{DateTime: day, Value: resting}}); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if steps {
+		sts := StepsTimeSeries{}
+		if err := c.get(ctx, fmt.Sprintf(`/1/user/%s/activities/steps/date/%s/1d/1min.json`, userID, date), &sts); err != nil {
+			return 0, fmt.Errorf("error grabbing steps data: %w", err)
+		}
+		if err := c.record(MetricSteps, datePoints(sts.ActivitiesStepsIntraday.Dataset, day, time.Minute)); err != nil {
+			return 0, err
+		}
+	}
+	return len(heartRate), nil
+}
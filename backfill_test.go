@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBackfill serves a day of heart rate for each date requested, failing the dates in fail once each.
+type fakeBackfill struct {
+	mu        sync.Mutex
+	fail      map[string]int // status to fail with
+	requested []string
+}
+
+func (f *fakeBackfill) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	date := strings.Split(strings.TrimPrefix(r.URL.Path, "/1/user/USER/activities/heart/date/"), "/")[0]
+	f.requested = append(f.requested, date)
+	if status, ok := f.fail[date]; ok {
+		delete(f.fail, date)
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(status)
+		fmt.Fprint(w, `{"errors":[{"errorType":"request","message":"failed"}],"success":false}`)
+		return
+	}
+	fmt.Fprintf(w, `{"activities-heart":[{"dateTime":"%s","value":{"restingHeartRate":55}}],
+		"activities-heart-intraday":{"dataset":[{"time":"00:00:00","value":60},{"time":"00:01:00","value":61}]}}`, date)
+}
+
+func (f *fakeBackfill) requests() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := strings.Join(f.requested, " ")
+	f.requested = nil
+	return r
+}
+
+func TestBackfill_resumes(t *testing.T) {
+	store, err := openStore(filepath.Join(t.TempDir(), "data.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	now := time.Date(2021, 3, 6, 23, 59, 0, 0, time.UTC)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	date := func(daysAgo int) string {
+		d, _ := dateHourMin(today.AddDate(0, 0, -daysAgo))
+		return d
+	}
+
+	fake := &fakeBackfill{fail: map[string]int{date(2): http.StatusBadRequest}}
+	c := newTestClient(t, fake)
+	c.Store = store
+	c.now = func() time.Time { return now }
+	from := today.AddDate(0, 0, -3)
+
+	if err := backfill(context.Background(), c, Config{}, from); err == nil {
+		t.Fatal("expected the failed day to stop the backfill")
+	}
+	if got, want := fake.requests(), strings.Join([]string{date(0), date(1), date(2)}, " "); got != want {
+		t.Errorf("requested %s, want %s", got, want)
+	}
+
+	if err := backfill(context.Background(), c, Config{}, from); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fake.requests(), strings.Join([]string{date(0), date(2), date(3)}, " "); got != want {
+		t.Errorf("requested %s on resuming, want today and the days not finished: %s", got, want)
+	}
+
+	if got := len(store.Range(MetricHeartRate, from, today.Add(24*time.Hour))); got != 8 {
+		t.Errorf("got %d heart-rate datapoints stored, want 2 for each of 4 days", got)
+	}
+	resting := store.Range(MetricRestingHeartRate, from, today)
+	if len(resting) != 4 || resting[0].Value != 55 {
+		t.Errorf("got resting heart rates %v, want 55 for each of 4 days", resting)
+	}
+}
+
+func TestBackfill_waitsForRateLimit(t *testing.T) {
+	store, err := openStore(filepath.Join(t.TempDir(), "data.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	now := time.Now().UTC()
+	today, _ := dateHourMin(now)
+
+	fake := &fakeBackfill{fail: map[string]int{today: http.StatusTooManyRequests}}
+	c := newTestClient(t, fake)
+	c.Store = store
+
+	if err := backfill(context.Background(), c, Config{}, now); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fake.requests(), today+" "+today; got != want {
+		t.Errorf("requested %s, want %s retried after the rate limit reset", got, want)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/Masterminds/sprig"
 	"gonum.org/v1/plot"
@@ -11,6 +12,7 @@ import (
 	"gonum.org/v1/plot/vg/vgsvg"
 	"image/color"
 	"log"
+	"math"
 	"strconv"
 	"strings"
 	"text/template"
@@ -19,8 +21,9 @@ import (
 
 // BannerXY represents a single point on the plot.
 type BannerXY struct {
-	X time.Time
-	Y int
+	X      time.Time
+	Y      int
+	Filled bool // filled in for a missing datapoint rather than measured
 }
 
 type Theme struct {
@@ -34,6 +37,80 @@ type Theme struct {
 	Axes         string `json:"axes"`
 	PlotLine     string `json:"plot_line"`
 	Heart        string `json:"heart"`
+
+	// Heart-rate zone colors shade the plot behind the line. Empty leaves a zone unshaded.
+	ZoneOutOfRange string `json:"zone_out_of_range"`
+	ZoneFatBurn    string `json:"zone_fat_burn"`
+	ZoneCardio     string `json:"zone_cardio"`
+	ZonePeak       string `json:"zone_peak"`
+
+	// Sleep stage colors fill the hypnogram on the sleep banner. Empty stages fall back to PlotLine.
+	SleepDeep  string `json:"sleep_deep"`
+	SleepLight string `json:"sleep_light"`
+	SleepREM   string `json:"sleep_rem"`
+	SleepWake  string `json:"sleep_wake"`
+
+	// Gap shades stretches without heart-rate data when gaps are shaded. Empty uses a faded Axes.
+	Gap string `json:"gap"`
+
+	// SpO2Band fills between the nightly min and max on the SpO2 banner. Empty uses a faded PlotLine.
+	SpO2Band string `json:"spo2_band"`
+
+	// Active Zone Minutes colors fill each zone's bars on the Active Zone Minutes banner. Empty zones fall back to PlotLine.
+	AZMFatBurn string `json:"azm_fat_burn"`
+	AZMCardio  string `json:"azm_cardio"`
+	AZMPeak    string `json:"azm_peak"`
+}
+
+// sleepStageColor returns the theme color for a hypnogram stage e.g., stageDeep.
+func (t Theme) sleepStageColor(stage int) string {
+	c := ""
+	switch stage {
+	case stageDeep:
+		c = t.SleepDeep
+	case stageLight:
+		c = t.SleepLight
+	case stageREM:
+		c = t.SleepREM
+	case stageWake:
+		c = t.SleepWake
+	}
+	if c == "" {
+		return t.PlotLine
+	}
+	return c
+}
+
+// azmColor returns the theme color for the Active Zone Minutes bars of the heart-rate zone named name.
+func (t Theme) azmColor(name string) string {
+	c := ""
+	switch name {
+	case "Fat Burn":
+		c = t.AZMFatBurn
+	case "Cardio":
+		c = t.AZMCardio
+	case "Peak":
+		c = t.AZMPeak
+	}
+	if c == "" {
+		return t.PlotLine
+	}
+	return c
+}
+
+// zoneColor returns the theme color for the heart-rate zone named name, or "" if it has none.
+func (t Theme) zoneColor(name string) string {
+	switch name {
+	case "Out of Range":
+		return t.ZoneOutOfRange
+	case "Fat Burn":
+		return t.ZoneFatBurn
+	case "Cardio":
+		return t.ZoneCardio
+	case "Peak":
+		return t.ZonePeak
+	}
+	return ""
 }
 
 type Template struct {
@@ -44,9 +121,13 @@ type Template struct {
 
 	Plot string
 
-	Heart       string
-	BPM         int
-	BPMTextSize int
+	// Icon is drawn on the left third of the banner, with Value over it and Caption under it.
+	Icon        string
+	Value       string
+	ValueSize   int
+	ValueColor  string
+	Caption     string
+	CaptionSize int
 
 	Title         string
 	TitleSize     int
@@ -55,44 +136,54 @@ type Template struct {
 }
 
 // BannerTicker is used to plot major and minor tick marks.
-var BannerTicker = func(timeSeries plotter.XYs) plot.TickerFunc {
+// Ticks fall on the hour and quarter hour of the wall clock in loc, whatever the interval between datapoints.
+// Plots of 2 hours or less are labeled every 15 mins, starting from the first datapoint.
+// Plots longer than 6 hours are labeled every few hours instead, so labels do not overlap.
+var BannerTicker = func(loc *time.Location) plot.TickerFunc {
 	return func(min, max float64) []plot.Tick {
-		ticks := make([]plot.Tick, 0, len(timeSeries))
-		for i, point := range timeSeries {
-			if max-min <= 3600*2 { // do we have less than 2 hours of data?
-				if i == 0 { // first tick, minute precision
-					ticks = append(ticks, plot.Tick{
-						Value: point.X,
-						Label: "00:00",
-					})
-					continue
-				}
-
-				if int(point.X)%900 == 0 { // every 15 mins
-					ticks = append(ticks, plot.Tick{
-						Value: point.X,
-						Label: "00:00",
-					})
-				}
-				continue
-			}
+		majorEvery, minorEvery := 3600, 900
+		ticks := make([]plot.Tick, 0)
+		if max-min <= 3600*2 { // do we have less than 2 hours of data?
+			majorEvery = 900
+			ticks = append(ticks, plot.Tick{ // first tick, minute precision
+				Value: min,
+				Label: "00:00",
+			})
+		} else if hours := (max - min) / 3600; hours > 6 {
+			majorEvery, minorEvery = 3600*int(math.Ceil(hours/6)), 3600
+		}
 
-			if int(point.X)%3600 == 0 { // tick every hour
-				ticks = append(ticks, plot.Tick{
-					Value: point.X,
-					Label: "00:00",
-				})
-			} else if int(point.X)%900 == 0 { // minor tick every 15 mins
-				ticks = append(ticks, plot.Tick{
-					Value: point.X,
-					Label: "", // empty string == minor tick
-				})
+		first := math.Ceil(min)
+		first += float64((minorEvery - mod(wallClockSeconds(first, loc), minorEvery)) % minorEvery)
+		for x := first; x <= max; x += float64(minorEvery) {
+			if x == min && len(ticks) > 0 {
+				continue // already labeled
 			}
+			label := "" // empty string == minor tick
+			if mod(wallClockSeconds(x, loc), majorEvery) == 0 {
+				label = "00:00"
+			}
+			ticks = append(ticks, plot.Tick{
+				Value: x,
+				Label: label,
+			})
 		}
 		return ticks
 	}
 }
 
+// mod returns a modulo m, which is never negative unlike a % m.
+func mod(a, m int) int {
+	return (a%m + m) % m
+}
+
+// wallClockSeconds returns the unix time x shifted by loc's UTC offset at that time,
+// so multiples of 3600 fall on the hour of the wall clock in loc.
+func wallClockSeconds(x float64, loc *time.Location) int {
+	_, offset := time.Unix(int64(x), 0).In(loc).Zone()
+	return int(x) + offset
+}
+
 func defaultBanner(c Config) string {
 	bg := fmt.Sprintf(`<rect width="100%%" height="100%%" fill="%s" />`, c.Theme.Background)
 	t := fmt.Sprintf(`<text x="%dpt" y="%dpt" fill="%s" style="font-family: sans-serif; font-weight:500;" text-anchor="middle">Banner not setup yet, or no data within range is available.</text>`, c.BannerWidth/2, c.BannerHeight/2, c.Theme.Title)
@@ -100,28 +191,20 @@ func defaultBanner(c Config) string {
 	return banner
 }
 
-func updateSVG(c *Config) (string, error) {
-	hrts, err := heartRateTimesSeries(c)
+func updateSVG(ctx context.Context, client *FitbitClient, c Config) (string, error) {
+	hrts, zones, err := client.heartRateTimesSeries(ctx, c)
 	if err != nil {
-		log.Print("Error grabbing time series", err.Error())
-		return "", fmt.Errorf("Error grabbing time series: %w", err)
+		return "", bannerError("grabbing time series", err)
 	}
-	banner, err := genBanner(hrts, *c)
+	banner, err := genBanner(hrts, zones, c)
 	if err != nil {
-		log.Print("Error generating banner: ", err.Error())
-		return "", fmt.Errorf("Error generating banner: %w", err)
+		return "", bannerError("generating banner", err)
 	}
 	return banner, nil
 }
 
-func genBanner(xy []BannerXY, config Config) (string, error) {
-	timeSeries := make(plotter.XYs, 0, len(xy))
-	for i := range xy {
-		timeSeries = append(timeSeries, plotter.XY{
-			X: float64(xy[i].X.Unix()),
-			Y: float64(xy[i].Y),
-		})
-	}
+func genBanner(xy []BannerXY, zones []HeartRateZone, config Config) (string, error) {
+	timeSeries := plotXYs(xy)
 
 	bpm := 0
 	if len(timeSeries) <= 0 {
@@ -132,36 +215,60 @@ func genBanner(xy []BannerXY, config Config) (string, error) {
 	thirdWidth := config.BannerWidth / 3 // heart takes up 1/3rd, plot 2/3rd
 	plotWidth := thirdWidth * 2
 
-	tzLabel, err := lookupFullTZ(config.TimezoneAbbreviation, config.Timezone)
-	if err != nil {
-		tzLabel = TZLabel{
-			Abbreviation: config.TimezoneAbbreviation,
-			Full:         "",
-			UTCOffset:    config.Timezone,
-		}
+	tData := newTemplate(config, config.BannerTitle)
+	tData.Plot = genPlot(timeSeries, plotWidth, config, heartRateUnderlays(xy, timeSeries, zones, config)...)
+	tData.Icon = genHeart(bpm, thirdWidth, config.Theme.Heart)
+	tData.Value = strconv.Itoa(bpm)
+	tData.ValueColor = config.Theme.HeartNumber
+	tData.Caption = "Current BPM"
+	return execTemplate(tData)
+}
+
+// plotXYs converts banner points into plottable ones, with X as unix time.
+func plotXYs(xy []BannerXY) plotter.XYs {
+	timeSeries := make(plotter.XYs, 0, len(xy))
+	for i := range xy {
+		timeSeries = append(timeSeries, plotter.XY{
+			X: float64(xy[i].X.Unix()),
+			Y: float64(xy[i].Y),
+		})
 	}
+	return timeSeries
+}
 
-	tData := Template{
+// newTemplate returns a Template with the fields every banner shares filled in.
+func newTemplate(config Config, title string) Template {
+	return Template{
 		Width:            config.BannerWidth,
 		Height:           config.BannerHeight,
 		PaddingTopBottom: 20,
 		Theme:            config.Theme,
-		Plot:             genPlot(timeSeries, plotWidth, config),
-		Heart:            genHeart(bpm, thirdWidth, config.Theme.Heart),
-		BPM:              bpm,
-		BPMTextSize:      19,
-		Title:            config.BannerTitle,
+		ValueSize:        35,
+		CaptionSize:      19,
+		Title:            title,
 		TitleSize:        12,
-		TZLabel:          tzLabel,
+		TZLabel:          configTZLabel(config, time.Now()),
 		ShowWatermark:    config.DisplayViewOnGitHub,
 	}
+}
 
-	t, err := template.New("banner").Funcs(sprig.GenericFuncMap()).Parse(tmplSVG)
+// execTemplate renders tData into a banner.
+func execTemplate(tData Template) (string, error) {
+	return execSVGTemplate(tmplSVG, tData)
+}
+
+// execSVGTemplate renders data with tmpl, which may use the header template shared by every banner.
+func execSVGTemplate(tmpl string, data interface{}) (string, error) {
+	t, err := template.New("banner").Funcs(sprig.GenericFuncMap()).Parse(tmplHeaderSVG)
+	if err != nil {
+		return "", err
+	}
+	t, err = t.Parse(tmpl)
 	if err != nil {
 		return "", err
 	}
 	b := new(bytes.Buffer)
-	err = t.Execute(b, tData)
+	err = t.Execute(b, data)
 	if err != nil {
 		return "", err
 	}
@@ -205,13 +312,80 @@ func RGBAFromString(s string) color.RGBA {
 	}
 }
 
-func genPlot(timeSeries plotter.XYs, width int, config Config) string {
+// genPlot plots timeSeries as a line over the given underlays e.g., heart-rate zone bands.
+func genPlot(timeSeries plotter.XYs, width int, config Config, underlays ...plot.Plotter) string {
+	p := newPlot(config)
+	p.Add(underlays...)
+
+	_, interval := config.detailLevel()
+	for _, seg := range lineSegments(timeSeries, config.Gaps.threshold(interval).Seconds()) {
+		line, err := plotter.NewLine(downsample(seg, width*len(seg)/len(timeSeries)+4))
+		if err != nil {
+			log.Panic(err)
+		}
+		line.Color = RGBAFromString(config.Theme.PlotLine)
+		p.Add(line)
+	}
+	return renderPlot(p, width, config)
+}
+
+// heartRateUnderlays returns what is drawn behind the heart-rate line: heart-rate zones, and gaps in xy if
+// they are shaded.
+func heartRateUnderlays(xy []BannerXY, timeSeries plotter.XYs, zones []HeartRateZone, config Config) []plot.Plotter {
+	underlays := zoneBands(timeSeries, zones, config.Theme)
+	if config.Gaps.Shade {
+		_, interval := config.detailLevel()
+		underlays = append(underlays, gapBands(timeSeries, gapSpans(xy, config.Gaps.threshold(interval)), config.Theme)...)
+	}
+	return underlays
+}
+
+// downsample reduces an evenly spaced timeSeries to about maxPoints points for rendering, e.g. one per unit of width.
+// The lowest and highest point of each bucket are kept, so short spikes such as a workout's peak are not smoothed away.
+// The first and last points are always kept, so the plotted range does not change.
+func downsample(timeSeries plotter.XYs, maxPoints int) plotter.XYs {
+	n := len(timeSeries)
+	if maxPoints < 4 || n <= maxPoints {
+		return timeSeries
+	}
+	buckets := (maxPoints - 2) / 2
+	inner := n - 2
+	sampled := make(plotter.XYs, 0, maxPoints)
+	sampled = append(sampled, timeSeries[0])
+	for b := 0; b < buckets; b++ {
+		lo, hi := 1+b*inner/buckets, 1+(b+1)*inner/buckets
+		minI, maxI := lo, lo
+		for i := lo; i < hi; i++ {
+			if timeSeries[i].Y < timeSeries[minI].Y {
+				minI = i
+			}
+			if timeSeries[i].Y > timeSeries[maxI].Y {
+				maxI = i
+			}
+		}
+		switch {
+		case minI == maxI:
+			sampled = append(sampled, timeSeries[minI])
+		case minI < maxI:
+			sampled = append(sampled, timeSeries[minI], timeSeries[maxI])
+		default:
+			sampled = append(sampled, timeSeries[maxI], timeSeries[minI])
+		}
+	}
+	return append(sampled, timeSeries[n-1])
+}
+
+// newPlot returns an empty plot themed by config, with time ticks along the X axis.
+func newPlot(config Config) *plot.Plot {
 	p, _ := plot.New()
 
+	loc := config.location()
 	p.X.Tick.Marker = plot.TimeTicks{
-		Ticker: BannerTicker(timeSeries),
+		Ticker: BannerTicker(loc),
 		Format: "15:04",
-		Time:   nil,
+		Time: func(t float64) time.Time {
+			return time.Unix(int64(t), 0).In(loc)
+		},
 	}
 
 	p.X.Tick.LineStyle.Color = RGBAFromString(config.Theme.Axes)
@@ -225,32 +399,64 @@ func genPlot(timeSeries plotter.XYs, width int, config Config) string {
 	p.Y.Tick.Label.Color = RGBAFromString(config.Theme.TextTicks)
 
 	p.BackgroundColor = RGBAFromString(config.Theme.Background)
+	return p
+}
 
-	line, err := plotter.NewLine(timeSeries)
-	if err != nil {
-		log.Panic(err)
-	}
-	line.Color = RGBAFromString(config.Theme.PlotLine)
-	p.Add(line)
-	vgCanvas := vgsvg.New(vg.Length(width), vg.Length(config.BannerHeight))
+// renderPlot draws p width wide as SVG to embed in a banner.
+func renderPlot(p *plot.Plot, width int, config Config) string {
+	return renderPlotAt(p, 0, width, config.BannerHeight)
+}
+
+// renderPlotAt draws p width by height as SVG, y down from the top of the plot area, so plots can be stacked.
+func renderPlotAt(p *plot.Plot, y, width, height int) string {
+	vgCanvas := vgsvg.New(vg.Length(width), vg.Length(height))
 	drawCanvas := draw.New(vgCanvas)
 	drawCanvas = draw.Crop(drawCanvas, 0, 0, 0, -5) // prevents top y axis label from getting chopped
 	p.Draw(drawCanvas)
 
 	buf := new(bytes.Buffer)
-	_, err = vgCanvas.WriteTo(buf)
+	_, err := vgCanvas.WriteTo(buf)
 	if err != nil {
 		fmt.Println("could not write SVG", err)
 	}
 	plotSVG := buf.String()
 
-	plotSVG = fmt.Sprintf(`<g transform="translate(%d,%d)"> %s </g>`, 0, 0, plotSVG)
+	plotSVG = fmt.Sprintf(`<g transform="translate(%d,%d)"> %s </g>`, 0, y, plotSVG)
 	plotSVG = strings.ReplaceAll(plotSVG, `font-family:Times;font-weight:normal;font-style:normal;font-size:10px;`, "") // remove in-line style
 	plotSVG = strings.ReplaceAll(plotSVG, `<?xml version="1.0"?>`, "")                                                  // cannot have multiple xml tags
 	plotSVG = strings.ReplaceAll(plotSVG, "<text", `<text class="text"`)
 	return plotSVG
 }
 
+// zoneBands returns a horizontal band for each heart-rate zone with a theme color.
+// Bands are clipped to the range of the data, so they do not change the plot's axes.
+func zoneBands(timeSeries plotter.XYs, zones []HeartRateZone, theme Theme) []plot.Plotter {
+	if len(timeSeries) == 0 {
+		return nil
+	}
+	xmin, xmax, ymin, ymax := plotter.XYRange(timeSeries)
+	bands := make([]plot.Plotter, 0, len(zones))
+	for _, zone := range zones {
+		zoneColor := theme.zoneColor(zone.Name)
+		if zoneColor == "" {
+			continue
+		}
+		lo, hi := math.Max(float64(zone.Min), ymin), math.Min(float64(zone.Max), ymax)
+		if lo >= hi {
+			continue
+		}
+		band, err := plotter.NewPolygon(plotter.XYs{{X: xmin, Y: lo}, {X: xmax, Y: lo}, {X: xmax, Y: hi}, {X: xmin, Y: hi}})
+		if err != nil {
+			log.Println("Error generating heart-rate zone band:", err)
+			continue
+		}
+		band.Color = RGBAFromString(zoneColor)
+		band.LineStyle.Width = 0
+		bands = append(bands, band)
+	}
+	return bands
+}
+
 func genHeart(bpm int, width int, heartColor string) string {
 	// https://codepen.io/tutsplus/pen/MLBMRw
 	viewBox := width + width/3
@@ -275,13 +481,20 @@ func genHeart(bpm int, width int, heartColor string) string {
 	return heart
 }
 
-// language=SVG
-var tmplSVG = `
-<svg xmlns="http://www.w3.org/2000/svg" id="banner" width="{{ .Width }}pt" height="{{add .Height .TitleSize .PaddingTopBottom }}pt">
-	<!-- Generated via https://github.com/f0nkey/fitbit-readme-stats -->
-	<rect width="100%" height="100%" fill="{{ .Theme.Background }}"/>
-	<style> .text {font: 600 9px "Arial", Sans-Serif; fill: {{ .Theme.Background }};} </style>
-	<g id="padding" transform="translate(0 {{ div .PaddingTopBottom 2 }})">
+// genSummaryText returns a line of text above and a line below the center of a width by height box,
+// framing a banner's Value in place of an icon.
+func genSummaryText(above, below string, width, height int, textColor string) string {
+	return fmt.Sprintf(`
+	<g transform="translate(%d %d)" style="font: 600 10pt 'Arial', Sans-Serif; fill: %s;" text-anchor="middle">
+		<text y="-30">%s</text>
+		<text y="36">%s</text>
+	</g>
+	`, width/2, height/2, textColor, above, below)
+}
+
+// tmplHeaderSVG defines the title, watermark and timezone label at the top of every banner.
+// language=GoTemplate
+var tmplHeaderSVG = `{{ define "header" }}
 		<text id="title" dominant-baseline="hanging" text-anchor="middle" style="font: 600 12pt 'Arial', Sans-Serif; fill: {{ .Theme.Title }}" x="{{div .Width 2}}pt"> 
 			{{.Title}}
 		</text>
@@ -297,18 +510,28 @@ var tmplSVG = `
 				<text id="title" dominant-baseline="hanging" text-anchor="end" style="font: 600 8pt 'Arial', Sans-Serif; fill: {{ .Theme.TimezoneText }};" x="{{sub .Width 5 }}pt">Times in {{ .TZLabel.Abbreviation }}</text>
 			</g>
 		{{ end }}
+{{ end }}`
+
+// language=SVG
+var tmplSVG = `
+<svg xmlns="http://www.w3.org/2000/svg" id="banner" width="{{ .Width }}pt" height="{{add .Height .TitleSize .PaddingTopBottom }}pt">
+	<!-- Generated via https://github.com/f0nkey/fitbit-readme-stats -->
+	<rect width="100%" height="100%" fill="{{ .Theme.Background }}"/>
+	<style> .text {font: 600 9px "Arial", Sans-Serif; fill: {{ .Theme.Background }};} </style>
+	<g id="padding" transform="translate(0 {{ div .PaddingTopBottom 2 }})">
+		{{ template "header" . }}
 		<g id="main-content" transform="translate(0 {{ add .TitleSize 6 }})">
 			<g id="plot" transform="translate(166,0)">
 				<!-- Generated by SVGo and Plotinum VG -->
 				{{.Plot}}
 			</g>
 			<g id="heart">
-				{{ .Heart }}
+				{{ .Icon }}
 			</g>
 			<g id="heart-text" transform="translate( {{ $WidthBy3 := div .Width 3 }} {{ div $WidthBy3 2 }} {{ div .Height 2 }})">
-				<text id="current-bpm-text" class="text" text-anchor="middle" x="0" y="79">Current BPM</text>
-				<text id="bpm-number" class="text" dominant-baseline="middle" text-anchor="middle" x="0" y="0">{{ .BPM }}</text>
-				<style> #current-bpm-text {font-size: {{ .BPMTextSize }}pt; fill: {{ .Theme.CurrentBPM}};}  #bpm-number {font-size: 35px; fill: {{ .Theme.HeartNumber }};}</style>
+				<text id="current-bpm-text" class="text" text-anchor="middle" x="0" y="79">{{ .Caption }}</text>
+				<text id="bpm-number" class="text" dominant-baseline="middle" text-anchor="middle" x="0" y="0">{{ .Value }}</text>
+				<style> #current-bpm-text {font-size: {{ .CaptionSize }}pt; fill: {{ .Theme.CurrentBPM}};}  #bpm-number {font-size: {{ .ValueSize }}px; fill: {{ .ValueColor }};}</style>
 			</g>
 		</g>
 	</g>
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+func Test_zoneBands(t *testing.T) {
+	timeSeries := plotter.XYs{{X: 0, Y: 70}, {X: 60, Y: 130}, {X: 120, Y: 90}}
+	zones := []HeartRateZone{
+		{Name: "Out of Range", Min: 30, Max: 94},
+		{Name: "Fat Burn", Min: 94, Max: 131},
+		{Name: "Cardio", Min: 131, Max: 159},
+		{Name: "Peak", Min: 159, Max: 220},
+	}
+	theme := Theme{ZoneFatBurn: "rgba(239, 172, 50, 30)", ZoneCardio: "rgba(239, 93, 50, 45)", ZonePeak: "rgba(239, 50, 50, 60)"}
+
+	bands := zoneBands(timeSeries, zones, theme)
+	if len(bands) != 1 { // out of range has no color; cardio and peak are above the data
+		t.Fatalf("got %d bands, want 1", len(bands))
+	}
+	xmin, xmax, ymin, ymax := plotter.XYRange(bands[0].(*plotter.Polygon).XYs[0])
+	if xmin != 0 || xmax != 120 || ymin != 94 || ymax != 130 {
+		t.Errorf("fat burn band spans x %v-%v y %v-%v, want x 0-120 y 94-130", xmin, xmax, ymin, ymax)
+	}
+}
+
+func TestBannerTicker(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata") // UTC+5:30
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := float64(time.Date(2021, 3, 6, 14, 7, 0, 0, time.UTC).Unix())
+	tests := []struct {
+		name       string
+		loc        *time.Location
+		hours      float64
+		wantLabels []string
+		wantMinor  int
+	}{
+		{"2 hours labeled every 15 mins", time.UTC, 2, []string{"14:07", "14:15", "14:30", "14:45", "15:00", "15:15", "15:30", "15:45", "16:00"}, 0},
+		{"4 hours labeled hourly", time.UTC, 4, []string{"15:00", "16:00", "17:00", "18:00"}, 12},
+		{"24 hours labeled every 4 hours", time.UTC, 24, []string{"16:00", "20:00", "00:00", "04:00", "08:00", "12:00"}, 18},
+		{"half hour offset", kolkata, 4, []string{"20:00", "21:00", "22:00", "23:00"}, 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ticks := BannerTicker(tt.loc)(start, start+tt.hours*3600)
+			labels := make([]string, 0)
+			minor := 0
+			for _, tick := range ticks {
+				if tick.Label == "" {
+					minor++
+					continue
+				}
+				labels = append(labels, time.Unix(int64(tick.Value), 0).In(tt.loc).Format("15:04"))
+			}
+			if strings.Join(labels, " ") != strings.Join(tt.wantLabels, " ") {
+				t.Errorf("labeled %v, want %v", labels, tt.wantLabels)
+			}
+			if minor != tt.wantMinor {
+				t.Errorf("got %d minor ticks, want %d", minor, tt.wantMinor)
+			}
+		})
+	}
+}
+
+func Test_downsample(t *testing.T) {
+	timeSeries := make(plotter.XYs, 0, 14400)
+	for i := 0; i < 14400; i++ { // 4 hours of 1 second data
+		timeSeries = append(timeSeries, plotter.XY{X: float64(i), Y: 60})
+	}
+	timeSeries[5000].Y = 180 // a short spike
+	timeSeries[9000].Y = 40
+
+	sampled := downsample(timeSeries, 333)
+	if len(sampled) > 333 {
+		t.Errorf("got %d points, want at most 333", len(sampled))
+	}
+	if sampled[0] != timeSeries[0] || sampled[len(sampled)-1] != timeSeries[len(timeSeries)-1] {
+		t.Error("expected the first and last points to be kept")
+	}
+	_, _, ymin, ymax := plotter.XYRange(sampled)
+	if ymin != 40 || ymax != 180 {
+		t.Errorf("got range %v-%v, want the spikes kept at 40-180", ymin, ymax)
+	}
+	for i := 1; i < len(sampled); i++ {
+		if sampled[i].X <= sampled[i-1].X {
+			t.Fatalf("points out of order at %d", i)
+		}
+	}
+
+	short := timeSeries[:100]
+	if got := downsample(short, 333); len(got) != len(short) {
+		t.Errorf("got %d points, want series shorter than maxPoints unchanged", len(got))
+	}
+}
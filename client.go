@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultBaseURL is the root of FitBit's Web API.
+const defaultBaseURL = "https://api.fitbit.com"
+
+// tokenRefreshMargin is how long before it expires the API token is refreshed.
+const tokenRefreshMargin = 5 * time.Minute
+
+// FitbitClient makes authenticated requests to FitBit's Web API.
+// Every endpoint shares its auth, error decoding and timeouts.
+type FitbitClient struct {
+	// BaseURL is the root of the API all requests are made against, e.g. https://api.fitbit.com.
+	BaseURL string
+
+	// HTTPClient sends every request. Its Timeout bounds how long a single request may take.
+	HTTPClient *http.Client
+
+	// AppCredentials holds generated fields when a new app is made at https://dev.fitbit.com/.
+	AppCredentials AppCredentials
+
+	// UserCredentials holds credentials to authenticate with and request from the FitBit Web API.
+	// Read it with credentials once requests are being made, since refreshes replace it.
+	UserCredentials UserCredentials
+
+	// OnTokenRefresh is called with the new credentials after the API token is refreshed. May be nil.
+	OnTokenRefresh func(UserCredentials) error
+
+	// Retry controls how GET requests and token refreshes that fail for transient reasons are retried.
+	Retry RetryPolicy
+
+	// Store keeps fetched datapoints between refreshes, so only those since the last one stored are fetched. May be nil.
+	Store *Store
+
+	// Telemetry records the outcome of each request and the latest data fetched, for /metrics. May be nil.
+	Telemetry *Telemetry
+
+	// Sinks receive each datapoint the first time it is fetched.
+	Sinks []Sink
+
+	now func() time.Time // the clock data ranges and token expiry are measured against; time.Now when nil
+
+	mu        sync.Mutex // guards UserCredentials, rateLimit, recorded and unsent
+	rateLimit RateLimit
+	recorded  map[Metric]*recordedSeries      // what was passed to Sinks of each metric, when there's no Store
+	unsent    map[Sink]map[Metric][]Datapoint // datapoints each sink failed to take, to retry
+	refreshMu sync.Mutex                      // held for the duration of a refresh so only one request spends the refresh token
+
+	panels dailyPanelCache // the dashboard's sleep and resting data, kept between refreshes
+}
+
+// NewFitbitClient returns a FitbitClient pointed at FitBit's servers.
+func NewFitbitClient(appCreds AppCredentials, userCreds UserCredentials) *FitbitClient {
+	return &FitbitClient{
+		BaseURL:         defaultBaseURL,
+		HTTPClient:      &http.Client{Timeout: 30 * time.Second},
+		AppCredentials:  appCreds,
+		UserCredentials: userCreds,
+		Retry:           defaultRetryPolicy,
+	}
+}
+
+// clock returns the current time.
+func (c *FitbitClient) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// do sends req and returns the response body. Non-200 responses are decoded into an error.
+// If the request budget is spent, a *RateLimitError is returned without sending req.
+func (c *FitbitClient) do(req *http.Request) ([]byte, error) {
+	if err := c.checkRateLimit(); err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		c.Telemetry.observeRequest(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		c.Telemetry.observeRequest(err)
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = decodeAPIError(resp, b)
+	}
+	c.updateRateLimit(resp, err)
+	c.Telemetry.observeRequest(err)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// credentials returns the user's current credentials.
+func (c *FitbitClient) credentials() UserCredentials {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.UserCredentials
+}
+
+// token returns an API token, refreshing it first if it is about to expire.
+func (c *FitbitClient) token(ctx context.Context) (string, error) {
+	creds := c.credentials()
+	if !creds.needsRefresh(c.clock()) {
+		return creds.APIToken, nil
+	}
+	if err := c.refreshUserCredentials(ctx, creds.APIToken); err != nil {
+		return "", err
+	}
+	return c.credentials().APIToken, nil
+}
+
+// get requests path from the API on behalf of the user and decodes the JSON response into v.
+// If FitBit reports the API token expired anyway, it is refreshed and the request is made again.
+func (c *FitbitClient) get(ctx context.Context, path string, v interface{}) error {
+	token, err := c.token(ctx)
+	if err != nil {
+		return err
+	}
+	err = c.getOnce(ctx, path, token, v)
+	var expired *ExpiredTokenError
+	if !errors.As(err, &expired) {
+		return err
+	}
+	if err = c.refreshUserCredentials(ctx, token); err != nil {
+		return err
+	}
+	if err = c.getOnce(ctx, path, c.credentials().APIToken, v); err != nil {
+		return fmt.Errorf("error after token refresh: %w", err)
+	}
+	return nil
+}
+
+// getOnce requests path with the given API token, retrying per c.Retry on transient failures.
+func (c *FitbitClient) getOnce(ctx context.Context, path string, token string, v interface{}) error {
+	var b []byte
+	err := c.Retry.retry(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+path, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Add("Authorization", "Bearer "+token)
+		b, err = c.do(req)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// refreshUserCredentials trades the refresh token for new user credentials and hands them to OnTokenRefresh.
+// staleToken is the API token the caller found expired; if it has already been replaced by a concurrent refresh,
+// nothing is requested, since FitBit only accepts a refresh token once.
+func (c *FitbitClient) refreshUserCredentials(ctx context.Context, staleToken string) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	current := c.credentials()
+	if current.APIToken != staleToken {
+		return nil
+	}
+	userCreds, err := c.reqUserCredentials(ctx, "", current.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("error refreshing tokens and credentials: %w", err)
+	}
+	c.mu.Lock()
+	c.UserCredentials = userCreds
+	c.mu.Unlock()
+	if c.OnTokenRefresh != nil {
+		if err = c.OnTokenRefresh(userCreds); err != nil {
+			return fmt.Errorf("error saving credentials after getting refresh token: %w", err)
+		}
+	}
+	return nil
+}
+
+// requiredScopes must be granted for the banner to work at all.
+var requiredScopes = []string{"heartrate"}
+
+// requestedScopes are asked for during setup. Those beyond requiredScopes enable optional banners.
+var requestedScopes = []string{"heartrate", "activity", "sleep", "oxygen_saturation", "respiratory_rate"}
+
+// missingScopes returns which of scopes are not in granted, FitBit's space separated list of granted scopes.
+func missingScopes(granted string, scopes []string) []string {
+	have := make(map[string]bool)
+	for _, s := range strings.Fields(granted) {
+		have[s] = true
+	}
+	missing := make([]string, 0)
+	for _, s := range scopes {
+		if !have[s] {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+// newScopeError returns an *InsufficientScopeError for scopes the user did not grant.
+func newScopeError(missing []string) error {
+	return &InsufficientScopeError{FitbitError{
+		StatusCode: http.StatusOK,
+		ErrorType:  "insufficient_scope",
+		Message:    strings.Join(missing, ", ") + " was not given as a scope permission",
+	}}
+}
+
+// requireScopes returns an *InsufficientScopeError without contacting FitBit if the user has not granted all of scopes.
+func (c *FitbitClient) requireScopes(scopes ...string) error {
+	if missing := missingScopes(c.credentials().Scope, scopes); len(missing) > 0 {
+		return newScopeError(missing)
+	}
+	return nil
+}
+
+// reqInitUserCredentials requests user credentials from FitBit for the first time.
+func (c *FitbitClient) reqInitUserCredentials(ctx context.Context, userAuthCode string) (UserCredentials, error) {
+	if userAuthCode == "" {
+		return UserCredentials{}, fmt.Errorf("no user auth code provided")
+	}
+	userCreds, err := c.reqUserCredentials(ctx, userAuthCode, "")
+	if err != nil {
+		return UserCredentials{}, fmt.Errorf("error grabbing user tokens and credentials: %w", err)
+	}
+	return userCreds, nil
+}
+
+// reqUserCredentials requests from FitBit the fields in the UserCredentials struct.
+// If requesting a refresh, userAuthCode must be empty and refreshToken filled out.
+// If not requesting a refresh, userAuthCode must be filled and refreshToken empty.
+func (c *FitbitClient) reqUserCredentials(ctx context.Context, userAuthCode string, refreshToken string) (UserCredentials, error) {
+	appCred := c.AppCredentials
+	vals := url.Values{}
+	vals.Add("clientId", appCred.OAuthClientID)
+	vals.Add("grant_type", "authorization_code")
+	if refreshToken != "" {
+		vals.Set("grant_type", "refresh_token")
+		vals.Set("refresh_token", refreshToken)
+	}
+
+	vals.Add("redirect_uri", "http://localhost:8090")
+	vals.Add("code", userAuthCode)
+	authHeader := "Basic " + base64.StdEncoding.EncodeToString([]byte(appCred.OAuthClientID+":"+appCred.ClientSecret))
+
+	var b []byte
+	requestedAt := c.clock()
+	err := c.Retry.retry(ctx, func() error {
+		r := strings.NewReader(vals.Encode())
+		req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/oauth2/token", r)
+		if err != nil {
+			return err
+		}
+		req.Header.Add("Authorization", authHeader)
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		b, err = c.do(req)
+		return err
+	})
+	if err != nil {
+		return UserCredentials{}, err
+	}
+
+	tokenResp := struct {
+		UserCredentials
+		ExpiresIn int `json:"expires_in"` // seconds
+	}{}
+	err = json.Unmarshal(b, &tokenResp)
+	if err != nil {
+		return UserCredentials{}, err
+	}
+	creds := tokenResp.UserCredentials
+	if tokenResp.ExpiresIn > 0 {
+		creds.ExpiresAt = requestedAt.Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	}
+	if missing := missingScopes(creds.Scope, requiredScopes); len(missing) > 0 {
+		return UserCredentials{}, newScopeError(missing)
+	}
+	if creds.APIToken == "" {
+		return UserCredentials{}, errors.New("api token empty")
+	}
+	if creds.RefreshToken == "" {
+		return UserCredentials{}, errors.New("refresh token is empty")
+	}
+
+	return creds, nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeFitbit serves a minimal imitation of FitBit's Web API. The API token is "expired" until a refresh is made.
+type fakeFitbit struct {
+	mu        sync.Mutex
+	refreshes int
+	requests  int
+}
+
+func (f *fakeFitbit) counts() (refreshes, requests int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.refreshes, f.requests
+}
+
+func (f *fakeFitbit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/oauth2/token" {
+		f.refreshes++
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "refresh-0" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors":[{"errorType":"invalid_grant","message":"Refresh token invalid"}],"success":false}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"token-1","refresh_token":"refresh-1","scope":"heartrate","user_id":"USER","expires_in":28800}`)
+		return
+	}
+
+	f.requests++
+	if r.Header.Get("Authorization") != "Bearer token-1" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"errors":[{"errorType":"expired_token","message":"Access token expired: token-0"}],"success":false}`)
+		return
+	}
+	fmt.Fprint(w, `{"activities-heart-intraday":{"dataset":[{"time":"00:00:00","value":60},{"time":"00:01:00","value":61}],"datasetInterval":1,"datasetType":"minute"}}`)
+}
+
+func newTestClient(t *testing.T, h http.Handler) *FitbitClient {
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	c := NewFitbitClient(AppCredentials{OAuthClientID: "ID", ClientSecret: "SECRET"}, UserCredentials{
+		APIToken:     "token-0",
+		RefreshToken: "refresh-0",
+		Scope:        "heartrate",
+		UserID:       "USER",
+	})
+	c.BaseURL = srv.URL
+	c.HTTPClient = srv.Client()
+	return c
+}
+
+func TestFitbitClient_refreshesExpiredToken(t *testing.T) {
+	fake := &fakeFitbit{}
+	c := newTestClient(t, fake)
+	saved := UserCredentials{}
+	c.OnTokenRefresh = func(uc UserCredentials) error {
+		saved = uc
+		return nil
+	}
+
+	xy, _, err := c.heartRateTimesSeries(context.Background(), Config{PlotRange: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(xy) != 2 {
+		t.Errorf("got %d points, want 2", len(xy))
+	}
+	if refreshes, requests := fake.counts(); refreshes != 1 || requests != 2 {
+		t.Errorf("got %d refreshes and %d requests, want 1 and 2", refreshes, requests)
+	}
+	if saved.APIToken != "token-1" || c.UserCredentials.RefreshToken != "refresh-1" {
+		t.Errorf("refreshed credentials not saved: hook got %+v, client has %+v", saved, c.UserCredentials)
+	}
+	if until := time.Until(saved.ExpiresAt); until < 7*time.Hour || until > 8*time.Hour {
+		t.Errorf("ExpiresAt %v is not 8 hours from now", saved.ExpiresAt)
+	}
+}
+
+func TestFitbitClient_refreshesBeforeExpiry(t *testing.T) {
+	fake := &fakeFitbit{}
+	c := newTestClient(t, fake)
+	c.UserCredentials.ExpiresAt = time.Now().Add(time.Minute)
+
+	if _, _, err := c.heartRateTimesSeries(context.Background(), Config{PlotRange: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if refreshes, requests := fake.counts(); refreshes != 1 || requests != 1 {
+		t.Errorf("got %d refreshes and %d requests, want 1 and 1", refreshes, requests)
+	}
+}
+
+func TestFitbitClient_concurrentRefresh(t *testing.T) {
+	fake := &fakeFitbit{}
+	c := newTestClient(t, fake)
+	c.UserCredentials.ExpiresAt = time.Now().Add(-time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := c.heartRateTimesSeries(context.Background(), Config{PlotRange: 4}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if refreshes, _ := fake.counts(); refreshes != 1 {
+		t.Errorf("got %d refreshes, want 1", refreshes)
+	}
+}
+
+func TestFitbitClient_failedRefresh(t *testing.T) {
+	c := newTestClient(t, &fakeFitbit{})
+	c.UserCredentials.RefreshToken = "revoked"
+	c.OnTokenRefresh = func(uc UserCredentials) error {
+		t.Error("OnTokenRefresh called after failed refresh")
+		return nil
+	}
+
+	if _, _, err := c.heartRateTimesSeries(context.Background(), Config{PlotRange: 4}); err == nil {
+		t.Error("expected error with a revoked refresh token")
+	}
+}
+
+func TestFitbitClient_backsOffWhenRateLimited(t *testing.T) {
+	requests := 0
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Fitbit-Rate-Limit-Limit", "150")
+		w.Header().Set("Fitbit-Rate-Limit-Remaining", "0")
+		w.Header().Set("Fitbit-Rate-Limit-Reset", "600")
+		fmt.Fprint(w, `{"activities-heart-intraday":{"dataset":[]}}`)
+	}))
+
+	if _, _, err := c.heartRateTimesSeries(context.Background(), Config{PlotRange: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if rl := c.RateLimit(); rl.Limit != 150 || rl.Remaining != 0 {
+		t.Errorf("RateLimit() = %+v, want 0 of 150 remaining", rl)
+	}
+
+	_, _, err := c.heartRateTimesSeries(context.Background(), Config{PlotRange: 4})
+	var rlErr *RateLimitError
+	if !errors.As(err, &rlErr) {
+		t.Fatalf("got error %v, want a *RateLimitError", err)
+	}
+	if rlErr.RetryAfter <= 9*time.Minute || rlErr.RetryAfter > 10*time.Minute {
+		t.Errorf("RetryAfter = %s, want about 10m", rlErr.RetryAfter)
+	}
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1 since the budget was spent", requests)
+	}
+}
+
+func TestFitbitClient_retriesServerErrors(t *testing.T) {
+	var requests int32
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"activities-heart-intraday":{"dataset":[{"time":"00:00:00","value":60}]}}`)
+	}))
+	c.Retry = RetryPolicy{MaxAttempts: 3, BaseDelay: 1, MaxDelay: 5, Jitter: jitter(0.5)}
+
+	if _, _, err := c.heartRateTimesSeries(context.Background(), Config{PlotRange: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+
+	atomic.StoreInt32(&requests, -10) // never succeeds within the attempts
+	_, _, err := c.heartRateTimesSeries(context.Background(), Config{PlotRange: 4})
+	var srvErr *ServerError
+	if !errors.As(err, &srvErr) {
+		t.Errorf("got error %v, want a *ServerError after attempts ran out", err)
+	}
+}
+
+func TestFitbitClient_retriesServerErrorsPromptly(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{"despite the rate limit reset", "Fitbit-Rate-Limit-Reset"},
+		{"after at most the max delay", "Retry-After"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&requests, 1) == 1 {
+					w.Header().Set(tt.header, "1800")
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				fmt.Fprint(w, `{"activities-heart-intraday":{"dataset":[{"time":"00:00:00","value":60}]}}`)
+			}))
+			c.Retry = RetryPolicy{MaxAttempts: 2, BaseDelay: 1, MaxDelay: 5, Jitter: jitter(0)}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, _, err := c.heartRateTimesSeries(ctx, Config{PlotRange: 4}); err != nil {
+				t.Fatal(err)
+			}
+			if n := atomic.LoadInt32(&requests); n != 2 {
+				t.Errorf("got %d requests, want the 503 retried once", n)
+			}
+		})
+	}
+}
+
+func TestFitbitClient_contextDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := c.heartRateTimesSeries(ctx, Config{PlotRange: 4}); err == nil {
+		t.Fatal("expected error from a request that outlived its deadline")
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("request took %s despite a 50ms deadline", waited)
+	}
+}
+
+func TestFitbitClient_stitchesMultiDayRanges(t *testing.T) {
+	paths := make([]string, 0)
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		sp := strings.Split(strings.TrimSuffix(r.URL.Path, ".json"), "/")
+		windowStart := sp[len(sp)-2] // .../time/HH:MM/HH:MM.json
+		fmt.Fprintf(w, `{"activities-heart-intraday":{"dataset":[{"time":"%s:00","value":60}]}}`, windowStart)
+	}))
+	config := Config{PlotRange: 48, TimezoneName: "UTC"}
+
+	xy, _, err := c.heartRateTimesSeries(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 3 {
+		t.Fatalf("got %d requests (%v), want one per day", len(paths), paths)
+	}
+	for i := 1; i < len(xy); i++ {
+		if !xy[i].X.After(xy[i-1].X) {
+			t.Fatalf("points out of order at %d: %v then %v", i, xy[i-1].X, xy[i].X)
+		}
+	}
+}
+
+func TestFitbitClient_detailLevel(t *testing.T) {
+	var path string
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		fmt.Fprint(w, `{"activities-heart-intraday":{"dataset":[{"time":"09:07:30","value":60},{"time":"09:07:33","value":62}]}}`)
+	}))
+	now := time.Date(2021, 3, 6, 10, 7, 30, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	config := Config{PlotRange: 1, TimezoneName: "UTC", DetailLevel: "1sec"}
+
+	xy, _, err := c.heartRateTimesSeries(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(path, "/1sec/time/") {
+		t.Errorf("requested %s, want 1sec detail", path)
+	}
+	if len(xy) != 4 {
+		t.Fatalf("got %d points, want gaps filled every second", len(xy))
+	}
+	if start := now.Add(-time.Hour); !xy[0].X.Equal(start) || !xy[3].X.Equal(start.Add(3*time.Second)) {
+		t.Errorf("got points from %v to %v, want %v to 3 seconds later", xy[0].X, xy[3].X, start)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gonum.org/v1/plot/plotter"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DashboardConfig configures the /dashboard.svg banner, which arranges several panels in a grid.
+type DashboardConfig struct {
+	// Title is the title at the top of the banner. Defaults to "My FitBit Dashboard".
+	Title string `json:"title"`
+
+	// Columns is how many cells wide the grid is. Each cell is banner_width/columns wide and banner_height tall. Defaults to 3.
+	Columns int `json:"columns"`
+
+	// Panels are placed in the grid left to right, wrapping onto a new row when one does not fit.
+	// Defaults to every panel type, with the heart-rate plot two cells wide.
+	Panels []DashboardPanel `json:"panels"`
+}
+
+// DashboardPanel is a panel in the dashboard grid.
+type DashboardPanel struct {
+	// Type is what the panel shows: heart, heart_plot, steps, sleep or resting.
+	Type string `json:"type"`
+
+	// Columns is how many cells wide the panel is. Defaults to 1.
+	Columns int `json:"columns"`
+}
+
+func (dc DashboardConfig) withDefaults() DashboardConfig {
+	if dc.Title == "" {
+		dc.Title = "My FitBit Dashboard"
+	}
+	if dc.Columns <= 0 {
+		dc.Columns = 3
+	}
+	if len(dc.Panels) == 0 {
+		dc.Panels = []DashboardPanel{
+			{Type: "heart"}, {Type: "heart_plot", Columns: 2},
+			{Type: "steps"}, {Type: "sleep"}, {Type: "resting"},
+		}
+	}
+	for i := range dc.Panels {
+		if dc.Panels[i].Columns <= 0 {
+			dc.Panels[i].Columns = 1
+		}
+		if dc.Panels[i].Columns > dc.Columns {
+			dc.Panels[i].Columns = dc.Columns
+		}
+	}
+	return dc
+}
+
+// panelGenerator renders a panel width by height from the dashboard's data.
+type panelGenerator func(d *dashboardData, width, height int) (Panel, error)
+
+// panelGenerators maps each panel type to its generator.
+var panelGenerators = map[string]panelGenerator{
+	"heart":      genHeartPanel,
+	"heart_plot": genHeartPlotPanel,
+	"steps":      genStepsPanel,
+	"sleep":      genSleepPanel,
+	"resting":    genRestingPanel,
+}
+
+// Panel is a rendered panel, placed in the grid by the dashboard template.
+type Panel struct {
+	X, Y          int
+	Width, Height int
+
+	// SVG is drawn from the top left of the panel, with Value over its center and Caption along its bottom.
+	SVG        string
+	Value      string
+	ValueSize  int
+	ValueColor string
+	Caption    string
+}
+
+// dashboardData is what panels are rendered from. The heart rate is fetched on first use,
+// so the heart and heart_plot panels share a request.
+type dashboardData struct {
+	ctx    context.Context
+	client *FitbitClient
+	config Config
+
+	heartRateFetched bool
+	heartRate        []BannerXY
+	zones            []HeartRateZone
+	heartRateErr     error
+}
+
+// heartRateTimesSeries returns the heart rate time series and zones, fetching them the first time.
+func (d *dashboardData) heartRateTimesSeries() ([]BannerXY, []HeartRateZone, error) {
+	if !d.heartRateFetched {
+		d.heartRate, d.zones, d.heartRateErr = d.client.heartRateTimesSeries(d.ctx, d.config)
+		d.heartRateFetched = true
+	}
+	return d.heartRate, d.zones, d.heartRateErr
+}
+
+// dailyPanelCache keeps the data of the dashboard's daily panels (sleep and resting) by key. FitBit summarizes it
+// once a day, so it is fetched again only after dailyRefreshInterval while the other panels are fetched every refresh.
+// The zero value is ready to use.
+type dailyPanelCache struct {
+	mu      sync.Mutex
+	entries map[string]dailyPanelEntry
+}
+
+type dailyPanelEntry struct {
+	value   interface{}
+	fetched time.Time
+}
+
+// get returns the value cached under key, calling fetch if there is none younger than dailyRefreshInterval at now.
+// Errors are not cached.
+func (pc *dailyPanelCache) get(key string, now time.Time, fetch func() (interface{}, error)) (interface{}, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if e, ok := pc.entries[key]; ok && now.Sub(e.fetched) < dailyRefreshInterval {
+		return e.value, nil
+	}
+	v, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	if pc.entries == nil {
+		pc.entries = make(map[string]dailyPanelEntry)
+	}
+	pc.entries[key] = dailyPanelEntry{value: v, fetched: now}
+	return v, nil
+}
+
+// lastNightsSleep returns last night's sleep, fetched at most every dailyRefreshInterval.
+func (d *dashboardData) lastNightsSleep() (SleepLog, error) {
+	v, err := d.client.panels.get("sleep", d.client.clock(), func() (interface{}, error) {
+		return d.client.lastNightsSleep(d.ctx, d.config)
+	})
+	if err != nil {
+		return SleepLog{}, err
+	}
+	return v.(SleepLog), nil
+}
+
+// restingHeartRates returns the resting heart rates of the past days, fetched at most every dailyRefreshInterval.
+func (d *dashboardData) restingHeartRates(days int) ([]BannerXY, error) {
+	v, err := d.client.panels.get(fmt.Sprint("resting ", days), d.client.clock(), func() (interface{}, error) {
+		return d.client.restingHeartRates(d.ctx, d.config, days)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]BannerXY), nil
+}
+
+func updateDashboardSVG(ctx context.Context, client *FitbitClient, c Config) (string, error) {
+	d := &dashboardData{ctx: ctx, client: client, config: c}
+	banner, err := genDashboard(d)
+	if err != nil {
+		return "", bannerError("generating dashboard", err)
+	}
+	return banner, nil
+}
+
+// genDashboard renders each configured panel into its place in the grid.
+// Panels without data are left blank; an error is returned only if none could be rendered.
+func genDashboard(d *dashboardData) (string, error) {
+	config := d.config
+	dashConfig := config.Dashboard.withDefaults()
+	cells, height := layoutPanels(dashConfig, config.BannerWidth/dashConfig.Columns, config.BannerHeight)
+
+	panels := make([]Panel, 0, len(dashConfig.Panels))
+	rendered := 0
+	var lastErr error
+	for i, dp := range dashConfig.Panels {
+		gen, ok := panelGenerators[dp.Type]
+		if !ok {
+			return "", fmt.Errorf("unknown dashboard panel %q", dp.Type)
+		}
+		cell := cells[i]
+		p, err := gen(d, cell.Width, cell.Height)
+		if err != nil {
+			log.Printf("Error generating %s panel: %s", dp.Type, err)
+			lastErr = err
+			p = Panel{Caption: "No " + dp.Type + " data"}
+		} else {
+			rendered++
+		}
+		p.X, p.Y, p.Width, p.Height = cell.X, cell.Y, cell.Width, cell.Height
+		if p.ValueColor == "" {
+			p.ValueColor = config.Theme.CurrentBPM
+		}
+		panels = append(panels, p)
+	}
+	if rendered == 0 && lastErr != nil {
+		return "", lastErr
+	}
+
+	tData := newTemplate(config, dashConfig.Title)
+	tData.Height = height
+	return execDashboardTemplate(tData, panels)
+}
+
+// layoutPanels places each of dc's panels in the grid, left to right, wrapping onto a new row when a panel
+// doesn't fit in what's left of the current one. It returns the empty panels in place and the grid's height.
+func layoutPanels(dc DashboardConfig, cellWidth, cellHeight int) ([]Panel, int) {
+	cells := make([]Panel, 0, len(dc.Panels))
+	col, row := 0, 0
+	for _, dp := range dc.Panels {
+		if col+dp.Columns > dc.Columns {
+			col, row = 0, row+1
+		}
+		cells = append(cells, Panel{X: col * cellWidth, Y: row * cellHeight, Width: cellWidth * dp.Columns, Height: cellHeight})
+		col += dp.Columns
+	}
+	return cells, (row + 1) * cellHeight
+}
+
+// genHeartPanel shows the beating heart with the current BPM, as on the stats banner.
+func genHeartPanel(d *dashboardData, width, height int) (Panel, error) {
+	xy, _, err := d.heartRateTimesSeries()
+	if err != nil {
+		return Panel{}, err
+	}
+	if len(xy) == 0 || xy[len(xy)-1].Y <= 0 {
+		return Panel{}, errors.New("no current heart rate")
+	}
+	bpm := xy[len(xy)-1].Y
+	size := width
+	if max := height * 5 / 3; size > max { // the heart's proportions on the stats banner
+		size = max
+	}
+	return Panel{
+		SVG:        fmt.Sprintf(`<g transform="translate(%d 0)"> %s </g>`, (width-size)/2, genHeart(bpm, size, d.config.Theme.Heart)),
+		Value:      strconv.Itoa(bpm),
+		ValueSize:  35,
+		ValueColor: d.config.Theme.HeartNumber,
+		Caption:    "Current BPM",
+	}, nil
+}
+
+// genHeartPlotPanel plots the heart rate over heart-rate zones, as on the stats banner.
+func genHeartPlotPanel(d *dashboardData, width, height int) (Panel, error) {
+	xy, zones, err := d.heartRateTimesSeries()
+	if err != nil {
+		return Panel{}, err
+	}
+	timeSeries := plotXYs(xy)
+	if len(timeSeries) == 0 {
+		return Panel{}, errors.New("data set empty")
+	}
+	panelConfig := d.config
+	panelConfig.BannerHeight = height
+	return Panel{SVG: genPlot(timeSeries, width, panelConfig, heartRateUnderlays(xy, timeSeries, zones, d.config)...)}, nil
+}
+
+// genStepsPanel shows progress toward the steps goal, as on the steps banner.
+func genStepsPanel(d *dashboardData, width, height int) (Panel, error) {
+	xy, err := d.client.stepsTimeSeries(d.ctx, d.config)
+	if err != nil {
+		return Panel{}, err
+	}
+	steps := 0
+	if len(xy) > 0 {
+		steps = xy[len(xy)-1].Y
+	}
+	goal := d.config.Steps.withDefaults().Goal
+	return Panel{
+		SVG:       genRing(float64(steps)/float64(goal), width, height, d.config.Theme.Heart, d.config.Theme.Axes),
+		Value:     thousands(steps),
+		ValueSize: 20,
+		Caption:   "Steps Today",
+	}, nil
+}
+
+// genSleepPanel summarizes last night's sleep, as on the sleep banner.
+func genSleepPanel(d *dashboardData, width, height int) (Panel, error) {
+	sl, err := d.lastNightsSleep()
+	if err != nil {
+		return Panel{}, err
+	}
+	loc := d.config.location()
+	bed, _ := time.ParseInLocation(sleepTimeLayout, sl.StartTime, loc)
+	wake, _ := time.ParseInLocation(sleepTimeLayout, sl.EndTime, loc)
+	return Panel{
+		SVG:       genSleepSummary(sl.Efficiency, bed, wake, width, height, d.config.Theme.CurrentBPM),
+		Value:     fmt.Sprintf("%dh %02dm", sl.MinutesAsleep/60, sl.MinutesAsleep%60),
+		ValueSize: 26,
+		Caption:   "Sleep",
+	}, nil
+}
+
+// genRestingPanel plots a sparkline of resting heart rates over the resting banner's period.
+func genRestingPanel(d *dashboardData, width, height int) (Panel, error) {
+	days := d.config.Resting.withDefaults().Days
+	xy, err := d.restingHeartRates(days)
+	if err != nil {
+		return Panel{}, err
+	}
+	timeSeries := plotXYs(xy)
+	if len(timeSeries) == 0 {
+		return Panel{}, errors.New("data set empty")
+	}
+	p := newPlot(d.config)
+	p.HideAxes()
+	line, err := plotter.NewLine(timeSeries)
+	if err != nil {
+		return Panel{}, err
+	}
+	line.Color = RGBAFromString(d.config.Theme.PlotLine)
+	p.Add(line)
+	return Panel{
+		SVG:     renderPlotAt(p, 10, width, height-30), // leaves room for the caption
+		Caption: fmt.Sprintf("Resting %.0f BPM (%dd avg)", meanY(xy), days),
+	}, nil
+}
+
+// dashboardTemplate is the data the dashboard template renders.
+type dashboardTemplate struct {
+	Template
+	Panels []Panel
+}
+
+// execDashboardTemplate renders panels below the header in tData.
+func execDashboardTemplate(tData Template, panels []Panel) (string, error) {
+	return execSVGTemplate(tmplDashboardSVG, dashboardTemplate{tData, panels})
+}
+
+// language=SVG
+var tmplDashboardSVG = `
+<svg xmlns="http://www.w3.org/2000/svg" id="banner" width="{{ .Width }}pt" height="{{add .Height .TitleSize .PaddingTopBottom }}pt">
+	<!-- Generated via https://github.com/f0nkey/fitbit-readme-stats -->
+	<rect width="100%" height="100%" fill="{{ .Theme.Background }}"/>
+	<style> .text {font: 600 9px "Arial", Sans-Serif; fill: {{ .Theme.Background }};} .caption {font-size: 10pt; fill: {{ .Theme.CurrentBPM }};} </style>
+	<g id="padding" transform="translate(0 {{ div .PaddingTopBottom 2 }})">
+		{{ template "header" . }}
+		<g id="main-content" transform="translate(0 {{ add .TitleSize 6 }})">
+			{{ range .Panels }}
+			<g class="panel" transform="translate({{ .X }} {{ .Y }})">
+				{{ .SVG }}
+				{{ if .Value }}<text class="text" dominant-baseline="middle" text-anchor="middle" x="{{ div .Width 2 }}" y="{{ div .Height 2 }}" style="font-size: {{ .ValueSize }}px; fill: {{ .ValueColor }};">{{ .Value }}</text>{{ end }}
+				{{ if .Caption }}<text class="text caption" text-anchor="middle" x="{{ div .Width 2 }}" y="{{ sub .Height 2 }}">{{ .Caption }}</text>{{ end }}
+			</g>
+			{{ end }}
+		</g>
+	</g>
+</svg>`
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDashboardConfig_withDefaults(t *testing.T) {
+	dc := DashboardConfig{Columns: 2, Panels: []DashboardPanel{{Type: "heart"}, {Type: "heart_plot", Columns: 5}}}.withDefaults()
+	if dc.Panels[0].Columns != 1 || dc.Panels[1].Columns != 2 {
+		t.Errorf("got panel columns %d and %d, want 1 and 2 (clamped to the grid)", dc.Panels[0].Columns, dc.Panels[1].Columns)
+	}
+}
+
+func Test_genDashboard(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testHeartRateJSON)
+	}))
+	config := testBannerConfig()
+	config.Dashboard = DashboardConfig{
+		Columns: 3,
+		Panels: []DashboardPanel{
+			{Type: "heart"}, {Type: "heart_plot", Columns: 2},
+			{Type: "steps"}, {Type: "heart_plot", Columns: 3},
+		},
+	}
+	d := &dashboardData{ctx: context.Background(), client: client, config: config}
+
+	banner, err := genDashboard(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`transform="translate(0 0)"`,   // heart
+		`transform="translate(166 0)"`, // heart plot beside it
+		`transform="translate(0 100)"`, // steps on the next row
+		`transform="translate(0 200)"`, // wide heart plot wraps onto a third row
+		">72<", "Current BPM", "No steps data",
+	} {
+		if !strings.Contains(banner, want) {
+			t.Errorf("dashboard is missing %q", want)
+		}
+	}
+	if !strings.Contains(banner, `height="332pt"`) {
+		t.Error("expected the dashboard to be three rows tall")
+	}
+}
+
+func Test_genDashboard_cachesDailyPanels(t *testing.T) {
+	var intraday, daily int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/time/") {
+			atomic.AddInt32(&intraday, 1)
+			fmt.Fprint(w, testHeartRateJSON)
+			return
+		}
+		atomic.AddInt32(&daily, 1)
+		fmt.Fprint(w, `{"activities-heart":[
+			{"dateTime":"2021-03-01","value":{"restingHeartRate":62}},
+			{"dateTime":"2021-03-02","value":{"restingHeartRate":60}}]}`)
+	}))
+	now := time.Date(2021, 3, 2, 12, 0, 0, 0, time.UTC)
+	client.now = func() time.Time { return now }
+	config := testBannerConfig()
+	config.Dashboard = DashboardConfig{Panels: []DashboardPanel{{Type: "heart"}, {Type: "resting"}}}
+
+	refreshes := []struct {
+		after    time.Duration
+		intraday int32
+		daily    int32
+	}{
+		{0, 1, 1},
+		{3 * time.Minute, 2, 1}, // resting heart rates are still fresh
+		{time.Hour, 3, 2},
+	}
+	for _, r := range refreshes {
+		now = now.Add(r.after)
+		banner, err := genDashboard(&dashboardData{ctx: context.Background(), client: client, config: config})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(banner, "Resting 61 BPM") {
+			t.Errorf("dashboard at %v is missing the resting panel", now)
+		}
+		if got := atomic.LoadInt32(&intraday); got != r.intraday {
+			t.Errorf("at %v got %d heart-rate requests, want %d", now, got, r.intraday)
+		}
+		if got := atomic.LoadInt32(&daily); got != r.daily {
+			t.Errorf("at %v got %d resting heart rate requests, want %d", now, got, r.daily)
+		}
+	}
+}
+
+func Test_layoutPanels(t *testing.T) {
+	dc := DashboardConfig{
+		Columns: 3,
+		Panels: []DashboardPanel{
+			{Type: "heart", Columns: 1}, {Type: "heart_plot", Columns: 2},
+			{Type: "steps", Columns: 1}, {Type: "heart_plot", Columns: 3},
+		},
+	}
+	cells, height := layoutPanels(dc, 166, 100)
+	want := []Panel{
+		{X: 0, Y: 0, Width: 166, Height: 100},   // heart
+		{X: 166, Y: 0, Width: 332, Height: 100}, // heart plot beside it
+		{X: 0, Y: 100, Width: 166, Height: 100}, // steps on the next row
+		{X: 0, Y: 200, Width: 498, Height: 100}, // wide heart plot wraps onto a third row
+	}
+	if len(cells) != len(want) {
+		t.Fatalf("got %d cells, want %d", len(cells), len(want))
+	}
+	for i := range want {
+		if cells[i] != want[i] {
+			t.Errorf("panel %d placed at %+v, want %+v", i, cells[i], want[i])
+		}
+	}
+	if height != 300 {
+		t.Errorf("got height %d, want three rows of 100", height)
+	}
+}
+
+func Test_genDashboard_noData(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	config := testBannerConfig()
+	config.Dashboard.Panels = []DashboardPanel{{Type: "heart"}, {Type: "steps"}}
+	d := &dashboardData{ctx: context.Background(), client: client, config: config}
+
+	if _, err := genDashboard(d); err == nil {
+		t.Error("expected an error when no panel has data, so the last dashboard is kept")
+	}
+}
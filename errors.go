@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FitbitError describes a request FitBit's Web API did not fulfill.
+// Every error type below wraps one, so errors.As with a *FitbitError matches any of them.
+type FitbitError struct {
+	// StatusCode is the HTTP status of FitBit's response.
+	StatusCode int
+	// ErrorType is FitBit's errorType field, e.g. expired_token or insufficient_scope.
+	ErrorType string
+	// Message is FitBit's description of the error.
+	Message string
+}
+
+func (e *FitbitError) Error() string {
+	if e.ErrorType == "" {
+		return fmt.Sprintf("%s (HTTP %d)", e.Message, e.StatusCode)
+	}
+	return fmt.Sprintf("%s (%s, HTTP %d)", e.Message, e.ErrorType, e.StatusCode)
+}
+
+// ExpiredTokenError means the API token has expired and must be refreshed.
+type ExpiredTokenError struct{ FitbitError }
+
+func (e *ExpiredTokenError) Unwrap() error { return &e.FitbitError }
+
+// InvalidGrantError means FitBit rejected an authorization code or refresh token,
+// e.g. because it was already used or the user revoked access. Setup must be run again.
+type InvalidGrantError struct{ FitbitError }
+
+func (e *InvalidGrantError) Unwrap() error { return &e.FitbitError }
+
+// InsufficientScopeError means the user did not give us permission to the requested data.
+type InsufficientScopeError struct{ FitbitError }
+
+func (e *InsufficientScopeError) Unwrap() error { return &e.FitbitError }
+
+// RateLimitError means the hourly request budget is spent.
+type RateLimitError struct {
+	FitbitError
+	// RetryAfter is how long until FitBit accepts requests again. Zero if unknown.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", e.FitbitError.Error(), e.RetryAfter)
+}
+
+func (e *RateLimitError) Unwrap() error { return &e.FitbitError }
+
+// ServerError means FitBit failed to handle the request on their end. It is usually temporary.
+type ServerError struct {
+	FitbitError
+	// RetryAfter is how long FitBit asked us to wait before retrying. Zero if unspecified.
+	RetryAfter time.Duration
+}
+
+func (e *ServerError) Unwrap() error { return &e.FitbitError }
+
+// decodeAPIError turns an unsuccessful response from FitBit into one of the error types above,
+// or a plain *FitbitError if it fits none of them.
+func decodeAPIError(resp *http.Response, body []byte) error {
+	fErr := FitbitError{StatusCode: resp.StatusCode, Message: resp.Status}
+	aErr := APIError{}
+	if err := json.Unmarshal(body, &aErr); err == nil && len(aErr.Errors) > 0 {
+		fErr.ErrorType = aErr.Errors[0].ErrorType
+		msgs := make([]string, 0, len(aErr.Errors))
+		for _, s := range aErr.Errors {
+			msgs = append(msgs, s.Message)
+		}
+		fErr.Message = strings.Join(msgs, ", ")
+	} else if len(body) > 0 {
+		fErr.Message = resp.Status + " - " + string(body)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return &RateLimitError{FitbitError: fErr, RetryAfter: retryAfter(resp.Header, "Fitbit-Rate-Limit-Reset", "Retry-After")}
+	case resp.StatusCode >= 500:
+		// Fitbit-Rate-Limit-Reset is sent with every response, so only Retry-After says how long a server error lasts.
+		return &ServerError{FitbitError: fErr, RetryAfter: retryAfter(resp.Header, "Retry-After")}
+	case fErr.ErrorType == "expired_token",
+		resp.StatusCode == http.StatusUnauthorized && strings.Contains(fErr.Message, "Access token expired"):
+		return &ExpiredTokenError{fErr}
+	case fErr.ErrorType == "invalid_grant":
+		return &InvalidGrantError{fErr}
+	case fErr.ErrorType == "insufficient_scope", fErr.ErrorType == "insufficient_permissions":
+		return &InsufficientScopeError{fErr}
+	}
+	return &fErr
+}
+
+// retryAfter reads how long to wait before retrying, in seconds, from the first of keys present in h.
+func retryAfter(h http.Header, keys ...string) time.Duration {
+	for _, key := range keys {
+		if secs, err := strconv.Atoi(h.Get(key)); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return 0
+}
+
+// setupHint returns advice for errors that can only be fixed by running setup again, or "" for any other error.
+func setupHint(err error) string {
+	var invalidGrant *InvalidGrantError
+	var insufficientScope *InsufficientScopeError
+	switch {
+	case errors.As(err, &invalidGrant):
+		return "FitBit rejected the authorization. Run this binary with the -setup flag to authorize again."
+	case errors.As(err, &insufficientScope):
+		return "FitBit was not given permission to the requested data. Run this binary with the -setup flag and allow access to all data asked for."
+	}
+	return ""
+}
+
+// bannerError logs err as the reason a banner could not be updated while doing what, with advice if setup
+// must be run again, and returns it wrapped.
+func bannerError(what string, err error) error {
+	log.Print("Error ", what, ": ", err.Error())
+	if hint := setupHint(err); hint != "" {
+		log.Print(hint)
+	}
+	return fmt.Errorf("Error %s: %w", what, err)
+}
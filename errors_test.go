@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_decodeAPIError(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		header     http.Header
+		body       string
+		wantType   string
+		wantStatus int
+	}{
+		{"expired token", 401, nil, `{"errors":[{"errorType":"expired_token","message":"Access token expired: abc"}],"success":false}`, "*main.ExpiredTokenError", 401},
+		{"expired token no errorType", 401, nil, `{"errors":[{"message":"Access token expired: abc"}],"success":false}`, "*main.ExpiredTokenError", 401},
+		{"invalid grant", 400, nil, `{"errors":[{"errorType":"invalid_grant","message":"Refresh token invalid"}],"success":false}`, "*main.InvalidGrantError", 400},
+		{"insufficient scope", 403, nil, `{"errors":[{"errorType":"insufficient_scope","message":"missing sleep"}],"success":false}`, "*main.InsufficientScopeError", 403},
+		{"rate limited", 429, http.Header{"Fitbit-Rate-Limit-Reset": {"120"}}, ``, "*main.RateLimitError", 429},
+		{"server error", 503, nil, `<html>down</html>`, "*main.ServerError", 503},
+		{"empty errors", 401, nil, `{"errors":[],"success":false}`, "*main.FitbitError", 401},
+		{"not json", 400, nil, `bad request`, "*main.FitbitError", 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Status: http.StatusText(tt.status), Header: tt.header}
+			if resp.Header == nil {
+				resp.Header = http.Header{}
+			}
+			err := decodeAPIError(resp, []byte(tt.body))
+			if got := fmt.Sprintf("%T", err); got != tt.wantType {
+				t.Errorf("decodeAPIError() type = %s, want %s", got, tt.wantType)
+			}
+			var fErr *FitbitError
+			if !errors.As(err, &fErr) {
+				t.Fatalf("decodeAPIError() = %v, not a *FitbitError", err)
+			}
+			if fErr.StatusCode != tt.wantStatus {
+				t.Errorf("StatusCode = %d, want %d", fErr.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func Test_decodeAPIError_retryAfter(t *testing.T) {
+	resp := &http.Response{StatusCode: 429, Header: http.Header{"Fitbit-Rate-Limit-Reset": {"120"}}}
+	var rlErr *RateLimitError
+	if !errors.As(decodeAPIError(resp, nil), &rlErr) {
+		t.Fatal("expected a *RateLimitError")
+	}
+	if rlErr.RetryAfter != 2*time.Minute {
+		t.Errorf("RetryAfter = %s, want 2m0s", rlErr.RetryAfter)
+	}
+
+	resp = &http.Response{StatusCode: 503, Header: http.Header{"Fitbit-Rate-Limit-Reset": {"1800"}}}
+	var srvErr *ServerError
+	if !errors.As(decodeAPIError(resp, nil), &srvErr) {
+		t.Fatal("expected a *ServerError")
+	}
+	if srvErr.RetryAfter != 0 {
+		t.Errorf("RetryAfter = %s, want 0s as the rate limit reset says nothing about the server error", srvErr.RetryAfter)
+	}
+	resp.Header.Set("Retry-After", "30")
+	if errors.As(decodeAPIError(resp, nil), &srvErr); srvErr.RetryAfter != 30*time.Second {
+		t.Errorf("RetryAfter = %s, want 30s from Retry-After", srvErr.RetryAfter)
+	}
+}
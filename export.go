@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportContentTypes maps each export format to the content type it is served with.
+var exportContentTypes = map[string]string{
+	"csv":   "text/csv; charset=utf-8",
+	"jsonl": "application/x-ndjson",
+	"json":  "application/json",
+}
+
+// exportQuery is what to export from the store, from the query string of /export or the -export flags.
+type exportQuery struct {
+	Format     string
+	Metrics    []Metric
+	Start, End time.Time // inclusive, in the location timestamps are written in
+}
+
+// exportRecord is a stored datapoint as exported.
+type exportRecord struct {
+	Metric Metric `json:"metric"`
+	Time   string `json:"time"` // RFC 3339, with the offset of the export's timezone
+	Value  int    `json:"value"`
+}
+
+// parseExportQuery reads an export from q:
+//   - format: csv (the default), jsonl or json
+//   - from and to: dates (YYYY-MM-DD, to is inclusive) or RFC 3339 times. Default to the start of today and now.
+//   - metric: comma separated metrics to export. Defaults to every metric in the store.
+//   - tz: the IANA timezone timestamps are written in and dates are read in. Defaults to loc.
+func parseExportQuery(q url.Values, store *Store, loc *time.Location, now time.Time) (exportQuery, error) {
+	eq := exportQuery{Format: q.Get("format")}
+	if eq.Format == "" {
+		eq.Format = "csv"
+	}
+	if _, ok := exportContentTypes[eq.Format]; !ok {
+		return exportQuery{}, fmt.Errorf("unknown export format %q, expected csv, jsonl or json", eq.Format)
+	}
+
+	if tz := q.Get("tz"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return exportQuery{}, fmt.Errorf("unknown timezone %q: %w", tz, err)
+		}
+	}
+	now = now.In(loc)
+	eq.Start = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	eq.End = now
+	var err error
+	if from := q.Get("from"); from != "" {
+		if eq.Start, err = parseExportTime(from, loc, false); err != nil {
+			return exportQuery{}, err
+		}
+	}
+	if to := q.Get("to"); to != "" {
+		if eq.End, err = parseExportTime(to, loc, true); err != nil {
+			return exportQuery{}, err
+		}
+	}
+	if eq.End.Before(eq.Start) {
+		return exportQuery{}, fmt.Errorf("export ends before it starts")
+	}
+
+	stored := make(map[Metric]bool)
+	for _, m := range store.Metrics() {
+		if m == MetricBackfilled {
+			continue // bookkeeping, not data
+		}
+		stored[m] = true
+		eq.Metrics = append(eq.Metrics, m)
+	}
+	if metrics := q.Get("metric"); metrics != "" {
+		eq.Metrics = nil
+		for _, m := range strings.Split(metrics, ",") {
+			m := Metric(strings.TrimSpace(m))
+			if !stored[m] {
+				return exportQuery{}, fmt.Errorf("no %q data stored", m)
+			}
+			eq.Metrics = append(eq.Metrics, m)
+		}
+	}
+	return eq, nil
+}
+
+// parseExportTime parses s as an RFC 3339 time or a date in loc. A date is its start, or its end if end is true.
+func parseExportTime(s string, loc *time.Location, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.In(loc), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing %q, expected YYYY-MM-DD or an RFC 3339 time", s)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1).Add(-time.Second)
+	}
+	return t, nil
+}
+
+// writeExport writes each metric in eq stored between its start and end to w, in eq's format.
+func writeExport(w io.Writer, store *Store, eq exportQuery) error {
+	bw := bufio.NewWriter(w)
+	cw := csv.NewWriter(bw)
+	enc := json.NewEncoder(bw)
+	if eq.Format == "csv" {
+		cw.Write([]string{"metric", "time", "value"})
+	}
+
+	n := 0
+	for _, m := range eq.Metrics {
+		for _, pt := range store.Range(m, eq.Start, eq.End) {
+			rec := exportRecord{Metric: m, Time: pt.DateTime.Format(time.RFC3339), Value: pt.Value}
+			var err error
+			switch eq.Format {
+			case "csv":
+				err = cw.Write([]string{string(rec.Metric), rec.Time, strconv.Itoa(rec.Value)})
+			case "jsonl":
+				err = enc.Encode(rec)
+			case "json":
+				if n == 0 {
+					bw.WriteString("[\n")
+				} else {
+					bw.WriteString(",")
+				}
+				err = enc.Encode(rec)
+			}
+			if err != nil {
+				return fmt.Errorf("error writing export: %w", err)
+			}
+			n++
+		}
+	}
+
+	switch eq.Format {
+	case "csv":
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return fmt.Errorf("error writing export: %w", err)
+		}
+	case "json":
+		if n == 0 {
+			bw.WriteString("[")
+		}
+		bw.WriteString("]\n")
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("error writing export: %w", err)
+	}
+	return nil
+}
+
+// serveExport serves the data in the store as a download, as described by the request's query string.
+// See parseExportQuery.
+func (s *server) serveExport(w http.ResponseWriter, r *http.Request) {
+	if !s.currentConfig().Export.authorize(w, r) {
+		return
+	}
+	store := s.client.Store
+	if store == nil {
+		http.Error(w, "nothing to export, set store in config.json", http.StatusNotFound)
+		return
+	}
+	eq, err := parseExportQuery(r.URL.Query(), store, s.currentConfig().location(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", exportContentTypes[eq.Format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="fitbit-%s-%s.%s"`,
+		eq.Start.Format("2006-01-02"), eq.End.Format("2006-01-02"), eq.Format))
+	if err := writeExport(w, store, eq); err != nil {
+		log.Print("Error serving export: ", err.Error())
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newExportStore returns a store holding two heart-rate datapoints and a resting heart rate on 2021-03-06 UTC.
+func newExportStore(t *testing.T) *Store {
+	store, err := openStore(filepath.Join(t.TempDir(), "data.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	day := time.Date(2021, 3, 6, 0, 0, 0, 0, time.UTC)
+	if _, err := store.Add(MetricHeartRate, []Datapoint{{DateTime: day.Add(14 * time.Hour), Value: 60}, {DateTime: day.Add(14*time.Hour + time.Minute), Value: 72}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Add(MetricRestingHeartRate, []Datapoint{{DateTime: day, Value: 55}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Add(MetricBackfilled, []Datapoint{{DateTime: day, Value: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestWriteExport(t *testing.T) {
+	store := newExportStore(t)
+	now := time.Date(2021, 3, 7, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"csv", "from=2021-03-06&metric=heart_rate",
+			"metric,time,value\nheart_rate,2021-03-06T14:00:00Z,60\nheart_rate,2021-03-06T14:01:00Z,72\n"},
+		{"jsonl of every metric", "format=jsonl&from=2021-03-06&to=2021-03-06",
+			`{"metric":"heart_rate","time":"2021-03-06T14:00:00Z","value":60}` + "\n" +
+				`{"metric":"heart_rate","time":"2021-03-06T14:01:00Z","value":72}` + "\n" +
+				`{"metric":"resting_heart_rate","time":"2021-03-06T00:00:00Z","value":55}` + "\n"},
+		{"json in another timezone", "format=json&from=2021-03-06T14:00:30Z&tz=Asia/Kolkata&metric=heart_rate",
+			"[\n" + `{"metric":"heart_rate","time":"2021-03-06T19:31:00+05:30","value":72}` + "\n]\n"},
+		{"empty json", "format=json&from=2021-03-01&to=2021-03-02", "[]\n"},
+		{"defaults to today", "", "metric,time,value\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			eq, err := parseExportQuery(q, store, time.UTC, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var b bytes.Buffer
+			if err := writeExport(&b, store, eq); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseExportQuery_errors(t *testing.T) {
+	store := newExportStore(t)
+	for _, query := range []string{
+		"format=xml",
+		"from=yesterday",
+		"from=2021-03-07&to=2021-03-06",
+		"metric=steps",
+		"tz=Mars/Olympus_Mons",
+	} {
+		q, _ := url.ParseQuery(query)
+		if _, err := parseExportQuery(q, store, time.UTC, time.Now()); err == nil {
+			t.Errorf("expected an error for %s", query)
+		}
+	}
+}
+
+// getWithToken requests url, sending token as a bearer token unless it's empty.
+func getWithToken(t *testing.T, client *http.Client, url, token string) *http.Response {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestServer_exportNotServedByDefault(t *testing.T) {
+	s := newServer(testBannerConfig())
+	s.client.Store = newExportStore(t)
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	resp := getWithToken(t, srv.Client(), srv.URL+"/export", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d, want 404 unless export is enabled", resp.StatusCode)
+	}
+
+	s.config.Export = EndpointConfig{Enabled: true, Token: "TOKEN"}
+	enabled := httptest.NewServer(s.handler())
+	defer enabled.Close()
+	resp = getWithToken(t, enabled.Client(), enabled.URL+"/export", "TOKEN")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d once enabled, want 200", resp.StatusCode)
+	}
+}
+
+func TestServer_serveExport(t *testing.T) {
+	config := testBannerConfig()
+	config.Export = EndpointConfig{Enabled: true, Token: "TOKEN"}
+	s := newServer(config)
+	srv := httptest.NewServer(http.HandlerFunc(s.serveExport))
+	defer srv.Close()
+
+	resp := getWithToken(t, srv.Client(), srv.URL+"/export", "TOKEN")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d without a store, want 404", resp.StatusCode)
+	}
+
+	s.client.Store = newExportStore(t)
+	for _, token := range []string{"", "WRONG"} {
+		resp := getWithToken(t, srv.Client(), srv.URL+"/export", token)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("got status %d with token %q, want 401", resp.StatusCode, token)
+		}
+	}
+
+	resp = getWithToken(t, srv.Client(), srv.URL+"/export?format=jsonl&from=2021-03-06&to=2021-03-06&metric=resting_heart_rate", "TOKEN")
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("got content type %q, want application/x-ndjson", ct)
+	}
+	if !strings.Contains(resp.Header.Get("Content-Disposition"), "fitbit-2021-03-06-2021-03-06.jsonl") {
+		t.Errorf("got content disposition %q, want a dated filename", resp.Header.Get("Content-Disposition"))
+	}
+	if want := `{"metric":"resting_heart_rate","time":"2021-03-06T00:00:00Z","value":55}` + "\n"; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+
+	resp = getWithToken(t, srv.Client(), srv.URL+"/export?format=xml", "TOKEN")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d for an unknown format, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"log"
+	"math"
+	"time"
+)
+
+// GapConfig controls how stretches without heart-rate data, e.g. while the watch charges, are plotted.
+type GapConfig struct {
+	// Policy is how missing datapoints are filled in: forward_fill repeats the last value, interpolate draws a
+	// straight line to the next value, and break leaves gaps longer than Threshold empty, splitting the line.
+	// Defaults to forward_fill.
+	Policy string `json:"policy"`
+
+	// Threshold is how long (in seconds) a gap must be to be broken or shaded. Shorter gaps are always filled in.
+	// Defaults to 600.
+	Threshold int `json:"threshold"`
+
+	// Shade when true shades gaps longer than Threshold in the plot background, whatever the policy.
+	Shade bool `json:"shade"`
+}
+
+const (
+	gapForwardFill = "forward_fill"
+	gapBreak       = "break"
+	gapInterpolate = "interpolate"
+)
+
+func (gc GapConfig) withDefaults() GapConfig {
+	if gc.Policy == "" {
+		gc.Policy = gapForwardFill
+	}
+	if gc.Threshold <= 0 {
+		gc.Threshold = 600
+	}
+	return gc
+}
+
+// threshold returns Threshold as a duration, at least interval so regular spacing never counts as a gap.
+func (gc GapConfig) threshold(interval time.Duration) time.Duration {
+	t := time.Duration(gc.withDefaults().Threshold) * time.Second
+	if t < interval {
+		return interval
+	}
+	return t
+}
+
+// fillGaps fills in the dataset so there is a datapoint every interval, following the gap policy.
+// Datapoints filled in are marked Filled.
+func fillGaps(data []Datapoint, interval time.Duration, gc GapConfig) []Datapoint {
+	gc = gc.withDefaults()
+	gapAmt := int64(interval / time.Second)
+	switch gc.Policy {
+	case gapInterpolate:
+		return interpolateGaps(sortDedup(data), gapAmt)
+	case gapBreak:
+		filled := make([]Datapoint, 0, len(data))
+		for _, seg := range splitAtGaps(sortDedup(data), gc.threshold(interval)) {
+			filled = append(filled, fillInGaps(seg, gapAmt)...)
+		}
+		return filled
+	}
+	return fillInGaps(data, gapAmt)
+}
+
+// splitAtGaps splits the dataset wherever consecutive datapoints are more than threshold apart.
+func splitAtGaps(data []Datapoint, threshold time.Duration) [][]Datapoint {
+	segments := make([][]Datapoint, 0, 1)
+	start := 0
+	for i := 1; i < len(data); i++ {
+		if data[i].DateTime.Sub(data[i-1].DateTime) > threshold {
+			segments = append(segments, data[start:i])
+			start = i
+		}
+	}
+	if start < len(data) {
+		segments = append(segments, data[start:])
+	}
+	return segments
+}
+
+// interpolateGaps fills in gaps in the dataset with values on the straight line between the datapoints either side,
+// so there is a datapoint every gapAmt seconds. data must be sorted without duplicate timestamps, as by sortDedup.
+func interpolateGaps(data []Datapoint, gapAmt int64) []Datapoint {
+	filled := make([]Datapoint, 0, len(data))
+	for i, pt := range data {
+		if i > 0 {
+			prev := data[i-1]
+			from, to := prev.DateTime.Unix(), pt.DateTime.Unix()
+			for t := from + gapAmt; t < to; t += gapAmt {
+				frac := float64(t-from) / float64(to-from)
+				filled = append(filled, Datapoint{
+					DateTime: time.Unix(t, 0).In(pt.DateTime.Location()),
+					Value:    prev.Value + int(math.Round(frac*float64(pt.Value-prev.Value))),
+					Filled:   true,
+				})
+			}
+		}
+		filled = append(filled, pt)
+	}
+	return filled
+}
+
+// gapSpans returns the stretches of xy longer than threshold without measured data: runs of filled in points,
+// or jumps between consecutive points. Each span runs from the last measured point before it to the first after it.
+func gapSpans(xy []BannerXY, threshold time.Duration) []timeWindow {
+	spans := make([]timeWindow, 0)
+	lastMeasured := -1
+	for i, pt := range xy {
+		if pt.Filled {
+			continue
+		}
+		if lastMeasured >= 0 && pt.X.Sub(xy[lastMeasured].X) > threshold {
+			spans = append(spans, timeWindow{xy[lastMeasured].X, pt.X})
+		}
+		lastMeasured = i
+	}
+	return spans
+}
+
+// gapBands returns a band behind each span, covering the height of the time series.
+func gapBands(timeSeries plotter.XYs, spans []timeWindow, theme Theme) []plot.Plotter {
+	if len(timeSeries) == 0 || len(spans) == 0 {
+		return nil
+	}
+	_, _, ymin, ymax := plotter.XYRange(timeSeries)
+	fill := RGBAFromString(theme.Axes)
+	fill.A = 40
+	if theme.Gap != "" {
+		fill = RGBAFromString(theme.Gap)
+	}
+	bands := make([]plot.Plotter, 0, len(spans))
+	for _, span := range spans {
+		x0, x1 := float64(span.start.Unix()), float64(span.end.Unix())
+		band, err := plotter.NewPolygon(plotter.XYs{{X: x0, Y: ymin}, {X: x1, Y: ymin}, {X: x1, Y: ymax}, {X: x0, Y: ymax}})
+		if err != nil {
+			log.Println("Error generating gap band:", err)
+			continue
+		}
+		band.Color = fill
+		band.LineStyle.Width = 0
+		bands = append(bands, band)
+	}
+	return bands
+}
+
+// lineSegments splits timeSeries wherever consecutive points are more than threshold seconds apart,
+// so each segment can be drawn as its own line.
+func lineSegments(timeSeries plotter.XYs, threshold float64) []plotter.XYs {
+	segments := make([]plotter.XYs, 0, 1)
+	start := 0
+	for i := 1; i < len(timeSeries); i++ {
+		if timeSeries[i].X-timeSeries[i-1].X > threshold {
+			segments = append(segments, timeSeries[start:i])
+			start = i
+		}
+	}
+	if start < len(timeSeries) {
+		segments = append(segments, timeSeries[start:])
+	}
+	return segments
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+// testGappyDataset has a minute of data, an hour off the charger, then two more minutes.
+func testGappyDataset() []Datapoint {
+	start := time.Date(2021, 3, 6, 14, 0, 0, 0, time.UTC)
+	return []Datapoint{
+		{DateTime: start, Value: 60},
+		{DateTime: start.Add(time.Minute), Value: 70},
+		{DateTime: start.Add(61 * time.Minute), Value: 130},
+		{DateTime: start.Add(62 * time.Minute), Value: 120},
+	}
+}
+
+func Test_fillGaps(t *testing.T) {
+	tests := []struct {
+		policy     string
+		wantLen    int
+		wantValues map[int]int // index: value
+	}{
+		{gapForwardFill, 63, map[int]int{1: 70, 2: 70, 31: 70, 61: 130}},
+		{gapInterpolate, 63, map[int]int{1: 70, 2: 71, 31: 100, 60: 129, 61: 130}},
+		{gapBreak, 4, map[int]int{1: 70, 2: 130}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			data := testGappyDataset()
+			got := fillGaps(data, time.Minute, GapConfig{Policy: tt.policy})
+			if len(got) != tt.wantLen {
+				t.Fatalf("got %d datapoints, want %d", len(got), tt.wantLen)
+			}
+			for i, want := range tt.wantValues {
+				if got[i].Value != want {
+					t.Errorf("datapoint %d = %d, want %d", i, got[i].Value, want)
+				}
+			}
+			for i := 1; i < len(got); i++ {
+				if !got[i].DateTime.After(got[i-1].DateTime) {
+					t.Fatalf("datapoints out of order at %d", i)
+				}
+			}
+		})
+	}
+}
+
+func Test_fillGaps_breakFillsShortGaps(t *testing.T) {
+	data := testGappyDataset()
+	data = append(data[:1], data[2:]...) // the first gap is now 2 minutes
+	data = append([]Datapoint{{DateTime: data[0].DateTime.Add(-3 * time.Minute), Value: 50}}, data...)
+
+	got := fillGaps(data, time.Minute, GapConfig{Policy: gapBreak})
+	values := make([]string, 0, len(got))
+	for _, pt := range got {
+		values = append(values, fmt.Sprint(pt.Value))
+	}
+	if want := "50 50 50 60 130 120"; strings.Join(values, " ") != want {
+		t.Errorf("got %s, want %s", strings.Join(values, " "), want)
+	}
+}
+
+func Test_gapSpans(t *testing.T) {
+	data := testGappyDataset()
+	xy := make([]BannerXY, 0)
+	for _, pt := range fillGaps(data, time.Minute, GapConfig{}) {
+		xy = append(xy, BannerXY{X: pt.DateTime, Y: pt.Value, Filled: pt.Filled})
+	}
+	spans := gapSpans(xy, 10*time.Minute)
+	if len(spans) != 1 || !spans[0].start.Equal(data[1].DateTime) || !spans[0].end.Equal(data[2].DateTime) {
+		t.Errorf("got spans %v, want the hour between the 2nd and 3rd datapoints", spans)
+	}
+	if spans := gapSpans(xy, 2*time.Hour); len(spans) != 0 {
+		t.Errorf("got spans %v, want none longer than the threshold", spans)
+	}
+}
+
+func Test_lineSegments(t *testing.T) {
+	timeSeries := plotter.XYs{{X: 0, Y: 60}, {X: 60, Y: 70}, {X: 3660, Y: 130}, {X: 3720, Y: 120}}
+	segments := lineSegments(timeSeries, 600)
+	if len(segments) != 2 || len(segments[0]) != 2 || len(segments[1]) != 2 {
+		t.Errorf("got segments %v, want two of two points", segments)
+	}
+}
+
+func Test_genBanner_brokenLine(t *testing.T) {
+	config := testBannerConfig()
+	config.TimezoneName = "UTC"
+	config.Gaps = GapConfig{Policy: gapBreak, Shade: true}
+	xy := make([]BannerXY, 0)
+	for _, pt := range fillGaps(testGappyDataset(), time.Minute, config.Gaps) {
+		xy = append(xy, BannerXY{X: pt.DateTime, Y: pt.Value, Filled: pt.Filled})
+	}
+
+	banner, err := genBanner(xy, nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(banner, ">14:15<") || !strings.Contains(banner, ">15:00<") {
+		t.Error("expected ticks every 15 mins across the gap")
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"time"
+)
+
+// HRVConfig configures the /hrv.svg banner.
+type HRVConfig struct {
+	// Title is the title at the top of the banner. Defaults to "My Heart Rate Variability (Past N Days)".
+	Title string `json:"title"`
+
+	// Days is how many days to plot. Defaults to 30.
+	Days int `json:"days"`
+}
+
+func (hc HRVConfig) withDefaults() HRVConfig {
+	if hc.Days <= 0 {
+		hc.Days = 30
+	}
+	if hc.Title == "" {
+		hc.Title = fmt.Sprintf("My Heart Rate Variability (Past %d Days)", hc.Days)
+	}
+	return hc
+}
+
+const (
+	// maxHRVRangeDays is the longest range of days FitBit returns HRV for in a single request.
+	maxHRVRangeDays = 30
+
+	// hrvAverageDays is how many days the rolling average covers.
+	hrvAverageDays = 7
+)
+
+// HRVSeries contains the heart rate variability of each day from FitBit's API.
+type HRVSeries struct {
+	HRV []struct {
+		DateTime string `json:"dateTime"`
+		Value    struct {
+			// DailyRMSSD is measured over the main sleep, DeepRMSSD over its deep sleep only.
+			DailyRMSSD float64 `json:"dailyRmssd"`
+			DeepRMSSD  float64 `json:"deepRmssd"`
+		} `json:"value"`
+	} `json:"hrv"`
+}
+
+// dailyHRV holds a point per day for each RMSSD, with X as unix time of the day's local midnight.
+type dailyHRV struct {
+	Daily, Deep plotter.XYs
+}
+
+// dailyHRV returns the RMSSD of each day from days-1 days ago through today. Days without data are left out.
+func (c *FitbitClient) dailyHRV(ctx context.Context, config Config, days int) (dailyHRV, error) {
+	loc := config.location()
+	now := c.clock().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	hrv := dailyHRV{}
+	for _, w := range dateWindows(today.AddDate(0, 0, -(days-1)), today, maxHRVRangeDays) {
+		startDate, _ := dateHourMin(w.start)
+		endDate, _ := dateHourMin(w.end)
+		path := fmt.Sprintf(`/1/user/%s/hrv/date/%s/%s.json`, c.credentials().UserID, startDate, endDate)
+
+		series := HRVSeries{}
+		if err := c.get(ctx, path, &series); err != nil {
+			return dailyHRV{}, fmt.Errorf("error grabbing HRV data: %w", err)
+		}
+		for _, day := range series.HRV {
+			date, err := time.ParseInLocation("2006-01-02", day.DateTime, loc)
+			if err != nil {
+				continue
+			}
+			x := float64(date.Unix())
+			if day.Value.DailyRMSSD > 0 {
+				hrv.Daily = append(hrv.Daily, plotter.XY{X: x, Y: day.Value.DailyRMSSD})
+			}
+			if day.Value.DeepRMSSD > 0 {
+				hrv.Deep = append(hrv.Deep, plotter.XY{X: x, Y: day.Value.DeepRMSSD})
+			}
+		}
+	}
+	return hrv, nil
+}
+
+func updateHRVSVG(ctx context.Context, client *FitbitClient, c Config) (string, error) {
+	days := c.HRV.withDefaults().Days
+	hrv, err := client.dailyHRV(ctx, c, days+hrvAverageDays-1) // the days before the first are needed for its average
+	if err != nil {
+		return "", bannerError("grabbing HRV", err)
+	}
+	banner, err := genHRVBanner(hrv, c)
+	if err != nil {
+		return "", bannerError("generating HRV banner", err)
+	}
+	return banner, nil
+}
+
+// genHRVBanner plots daily and deep sleep RMSSD over the configured days with their rolling average,
+// with the latest average on the left.
+func genHRVBanner(hrv dailyHRV, config Config) (string, error) {
+	hrvConfig := config.HRV.withDefaults()
+	loc := config.location()
+	now := time.Now().In(loc)
+	periodStart := float64(time.Date(now.Year(), now.Month(), now.Day()-(hrvConfig.Days-1), 0, 0, 0, 0, loc).Unix())
+
+	average := sincePeriodStart(rollingAverage(hrv.Daily, hrvAverageDays, loc), periodStart)
+	daily := sincePeriodStart(hrv.Daily, periodStart)
+	deep := sincePeriodStart(hrv.Deep, periodStart)
+	if len(daily) <= 0 {
+		return defaultBanner(config), fmt.Errorf("data set empty")
+	}
+
+	thirdWidth := config.BannerWidth / 3 // summary takes up 1/3rd, plot 2/3rd
+	plotWidth := thirdWidth * 2
+
+	p, err := nightlyPlot(daily, "RMSSD (ms)", config)
+	if err != nil {
+		return "", err
+	}
+	dailyKey := &plotter.Line{LineStyle: plotter.DefaultLineStyle} // styled like the line nightlyPlot drew
+	dailyKey.Color = RGBAFromString(config.Theme.PlotLine)
+	p.Legend.Add("Daily", dailyKey)
+	if len(deep) > 0 {
+		deepLine, err := plotter.NewLine(deep)
+		if err != nil {
+			return "", err
+		}
+		deepLine.Color = RGBAFromString(config.Theme.Axes)
+		deepLine.Dashes = []vg.Length{2, 2}
+		p.Add(deepLine)
+		p.Legend.Add("Deep sleep", deepLine)
+	}
+	avgLine, err := plotter.NewLine(average)
+	if err != nil {
+		return "", err
+	}
+	avgLine.Color = RGBAFromString(config.Theme.Heart)
+	avgLine.Width = vg.Points(2)
+	p.Add(avgLine)
+	p.Legend.Add(fmt.Sprintf("%d day avg", hrvAverageDays), avgLine)
+	p.Legend.Top = true
+	p.Legend.TextStyle.Color = RGBAFromString(config.Theme.TextTicks)
+
+	tData := newTemplate(config, hrvConfig.Title)
+	tData.Plot = renderPlot(p, plotWidth, config)
+	tData.Icon = genSummaryText(fmt.Sprintf("%d day average", hrvAverageDays), fmt.Sprintf("Last night %.0f ms", daily[len(daily)-1].Y), thirdWidth, config.BannerHeight, config.Theme.CurrentBPM)
+	tData.Value = fmt.Sprintf("%.0f", average[len(average)-1].Y)
+	tData.ValueColor = config.Theme.CurrentBPM
+	tData.Caption = "HRV (ms)"
+	return execTemplate(tData)
+}
+
+// rollingAverage returns the average of each point in timeSeries and those from the days-1 days before it.
+// timeSeries must be sorted by X, with a point per local day at most.
+func rollingAverage(timeSeries plotter.XYs, days int, loc *time.Location) plotter.XYs {
+	averages := make(plotter.XYs, 0, len(timeSeries))
+	first, sum := 0, 0.0
+	for _, pt := range timeSeries {
+		sum += pt.Y
+		windowStart := float64(time.Unix(int64(pt.X), 0).In(loc).AddDate(0, 0, -(days - 1)).Unix())
+		for timeSeries[first].X < windowStart {
+			sum -= timeSeries[first].Y
+			first++
+		}
+		averages = append(averages, plotter.XY{X: pt.X, Y: sum / float64(len(averages)+1-first)})
+	}
+	return averages
+}
+
+// sincePeriodStart returns the points in timeSeries at or after start.
+func sincePeriodStart(timeSeries plotter.XYs, start float64) plotter.XYs {
+	for i, pt := range timeSeries {
+		if pt.X >= start {
+			return timeSeries[i:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+func Test_rollingAverage(t *testing.T) {
+	day := func(d int) float64 { return float64(time.Date(2021, 3, d, 0, 0, 0, 0, time.UTC).Unix()) }
+	timeSeries := plotter.XYs{{X: day(1), Y: 10}, {X: day(2), Y: 20}, {X: day(3), Y: 30}, {X: day(6), Y: 40}}
+	want := []float64{10, 15, 20, 35} // the 6th averages only itself and the 3rd, as the 4th and 5th are missing
+	got := rollingAverage(timeSeries, 4, time.UTC)
+	if len(got) != len(want) {
+		t.Fatalf("got %d averages, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Y != want[i] || got[i].X != timeSeries[i].X {
+			t.Errorf("average %d = %v, want %v at %v", i, got[i], want[i], timeSeries[i].X)
+		}
+	}
+}
+
+func TestFitbitClient_dailyHRV(t *testing.T) {
+	var requests int32
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/hrv/date/") {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		if atomic.AddInt32(&requests, 1) > 1 {
+			fmt.Fprint(w, `{"hrv":[]}`)
+			return
+		}
+		fmt.Fprint(w, `{"hrv":[{"dateTime":"2021-10-25","value":{"dailyRmssd":34.9,"deepRmssd":31.5}},{"dateTime":"2021-10-26","value":{"dailyRmssd":40.1}}]}`)
+	}))
+
+	hrv, err := c.dailyHRV(context.Background(), Config{TimezoneName: "UTC"}, 45)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("got %d requests for 45 days, want 2", n)
+	}
+	if len(hrv.Daily) != 2 || len(hrv.Deep) != 1 || hrv.Deep[0].Y != 31.5 {
+		t.Errorf("got daily %v deep %v, want 2 daily and 1 deep sleep RMSSD", hrv.Daily, hrv.Deep)
+	}
+}
+
+func Test_genHRVBanner(t *testing.T) {
+	config := testBannerConfig()
+	config.TimezoneName = "UTC"
+	config.HRV.Days = 3
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	hrv := dailyHRV{}
+	for i := 9; i >= 0; i-- {
+		x := float64(today.AddDate(0, 0, -i).Unix())
+		hrv.Daily = append(hrv.Daily, plotter.XY{X: x, Y: 30})
+		hrv.Deep = append(hrv.Deep, plotter.XY{X: x, Y: 25})
+	}
+	hrv.Daily[len(hrv.Daily)-1].Y = 44
+
+	banner, err := genHRVBanner(hrv, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{">32<", "Last night 44 ms", "Past 3 Days", "Daily", "7 day avg"} {
+		if !strings.Contains(banner, want) {
+			t.Errorf("banner is missing %q", want)
+		}
+	}
+}
@@ -1,16 +1,26 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
 	"time"
+	_ "time/tzdata" // timezone_name lookups on hosts without a tz database e.g., Windows
 )
 
 func main() {
 	setupMode := flag.Bool("setup", false, "run through the setup process to generate credentials.json, instead of serving the SVG normally")
+	exportFormat := flag.String("export", "", "write data in the store to stdout as csv, jsonl or json, instead of serving the SVG. See -from, -to, -metric and -tz")
+	exportFrom := flag.String("from", "", "with -export, the date (YYYY-MM-DD) or RFC 3339 time to export from. Defaults to the start of today")
+	exportTo := flag.String("to", "", "with -export, the date (YYYY-MM-DD, inclusive) or RFC 3339 time to export to. Defaults to now")
+	exportMetrics := flag.String("metric", "", "with -export, comma separated metrics to export e.g., heart_rate,steps. Defaults to every stored metric")
+	exportTZ := flag.String("tz", "", "with -export, the IANA timezone to write timestamps in. Defaults to the config's timezone")
+	backfillFrom := flag.String("backfill", "", "fetch data from this date (YYYY-MM-DD) through today into the store, instead of serving the SVG. Resumes where an interrupted backfill left off")
 	flag.Parse()
 
 	if *setupMode {
@@ -23,32 +33,71 @@ func main() {
 		fmt.Println("Error reading config file (use -setup flag on this binary if you have not already):", err)
 		pressEnterToExit()
 	}
-	if err = validateConfig(config); err != nil {
+	if err = validateConfig(&config); err != nil {
 		fmt.Println("Error validating config file (use -setup flag on this binary if you have not already):", err)
 		pressEnterToExit()
 	}
 
-	lastSVGGeneration := time.Unix(0, 0)
-	lastHydratedBanner := ""
-	currentBanner := defaultBanner(config)
-	http.HandleFunc("/stats.svg", func(w http.ResponseWriter, r *http.Request) {
-		if time.Since(lastSVGGeneration) > time.Second*time.Duration(config.CacheInvalidationTime) {
-			currentBanner, err = updateSVG(&config)
-			if err != nil {
-				currentBanner = lastHydratedBanner
-				if currentBanner == "" {
-					currentBanner = defaultBanner(config)
-				}
-			}
-			lastSVGGeneration = time.Now()
+	export := url.Values{"format": {*exportFormat}, "from": {*exportFrom}, "to": {*exportTo}, "metric": {*exportMetrics}, "tz": {*exportTZ}}
+	if err := run(config, *backfillFrom, export); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run backfills the store from backfillFrom, or exports it as the export query asks, if either is set.
+// Otherwise it serves the banners until the server fails.
+func run(config Config, backfillFrom string, export url.Values) error {
+	srv := newServer(config)
+	if config.Store != "" {
+		store, err := openStore(config.Store)
+		if err != nil {
+			return fmt.Errorf("Error opening store: %w", err)
 		}
-		lastHydratedBanner = currentBanner
-		w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
-		w.Header().Set("Cache-Control", "no-store, no-cache, max-age=0")
-		fmt.Fprint(w, currentBanner)
-	})
+		defer store.Close()
+		srv.client.Store = store
+	}
+
+	if backfillFrom != "" {
+		from, err := time.ParseInLocation("2006-01-02", backfillFrom, config.location())
+		if err != nil {
+			return fmt.Errorf("Error parsing backfill start date, expected YYYY-MM-DD: %w", err)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if err := backfill(ctx, srv.client, config, from); err != nil {
+			return fmt.Errorf("Error backfilling (run again to resume): %w", err)
+		}
+		fmt.Println("Backfill complete.")
+		return nil
+	}
+
+	if export.Get("format") != "" {
+		if srv.client.Store == nil {
+			return fmt.Errorf("Nothing to export, set store in config.json")
+		}
+		eq, err := parseExportQuery(export, srv.client.Store, config.location(), time.Now())
+		if err == nil {
+			err = writeExport(os.Stdout, srv.client.Store, eq)
+		}
+		if err != nil {
+			return fmt.Errorf("Error exporting: %w", err)
+		}
+		return nil
+	}
+
+	go srv.run(context.Background())
 	fmt.Println("Ensure Bluetooth is enabled on your phone so data can sync to FitBit's servers, as well as Battery Saver mode being off.")
-	fmt.Println("Use the following README embed:", "![FitBit Heart Rate Chart](http://HOSTIP:"+strconv.Itoa(config.Port)+"/stats.svg)")
+	for _, path := range srv.paths() {
+		fmt.Println("Use the following README embed:", "![FitBit Chart](http://HOSTIP:"+strconv.Itoa(config.Port)+path+")")
+	}
+	if config.Metrics.Enabled {
+		fmt.Println("Metrics can be scraped by Prometheus from http://HOSTIP:" + strconv.Itoa(config.Port) + "/metrics with the metrics token")
+	}
+	if config.Export.Enabled && srv.client.Store != nil {
+		fmt.Println("Stored data can be downloaded from http://HOSTIP:" + strconv.Itoa(config.Port) + "/export with the export token")
+	}
+	http.Handle("/", srv.handler())
 	fmt.Println("Serving on port", strconv.Itoa(config.Port)+".")
-	http.ListenAndServe(":"+strconv.Itoa(config.Port), nil)
+	return http.ListenAndServe(":"+strconv.Itoa(config.Port), nil)
 }
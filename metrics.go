@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// apiErrorTypes are the types API errors are counted by, as returned by apiErrorType.
+var apiErrorTypes = []string{"expired_token", "insufficient_scope", "invalid_grant", "network", "other", "rate_limit", "server", "timeout"}
+
+// apiErrorType classifies err, returned from a request to FitBit, for counting.
+func apiErrorType(err error) string {
+	var (
+		expired     *ExpiredTokenError
+		invalid     *InvalidGrantError
+		scope       *InsufficientScopeError
+		rateLimit   *RateLimitError
+		server      *ServerError
+		fitbitError *FitbitError
+	)
+	switch {
+	case errors.As(err, &expired):
+		return "expired_token"
+	case errors.As(err, &invalid):
+		return "invalid_grant"
+	case errors.As(err, &scope):
+		return "insufficient_scope"
+	case errors.As(err, &rateLimit):
+		return "rate_limit"
+	case errors.As(err, &server):
+		return "server"
+	case errors.As(err, &fitbitError):
+		return "other"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return "timeout"
+	}
+	return "network"
+}
+
+// Telemetry records what the latest data from FitBit showed and how fetching it went, to be scraped by
+// Prometheus from /metrics. Its methods do nothing on a nil *Telemetry. It is safe for concurrent use.
+type Telemetry struct {
+	mu               sync.Mutex
+	heartRate        observation
+	restingHeartRate observation
+	stepsToday       observation
+	lastSuccess      time.Time // of a request to FitBit
+	apiErrors        map[string]int
+	renders          map[string]renderStats // by banner name
+}
+
+// observation is the latest value of a measurement and when it was measured.
+type observation struct {
+	value int
+	at    time.Time
+}
+
+// renderStats sums up the time taken to generate a banner.
+type renderStats struct {
+	count int
+	sum   time.Duration
+}
+
+func newTelemetry() *Telemetry {
+	return &Telemetry{apiErrors: make(map[string]int), renders: make(map[string]renderStats)}
+}
+
+// observeHeartRate records the latest heart rate measured, at.
+func (t *Telemetry) observeHeartRate(bpm int, at time.Time) {
+	if t == nil {
+		return
+	}
+	t.observe(&t.heartRate, bpm, at)
+}
+
+// observeRestingHeartRate records the resting heart rate of the day starting at.
+func (t *Telemetry) observeRestingHeartRate(bpm int, at time.Time) {
+	if t == nil {
+		return
+	}
+	t.observe(&t.restingHeartRate, bpm, at)
+}
+
+// observeStepsToday records the steps taken so far on the day of at, as of at.
+func (t *Telemetry) observeStepsToday(steps int, at time.Time) {
+	if t == nil {
+		return
+	}
+	t.observe(&t.stepsToday, steps, at)
+}
+
+// observe replaces o unless it was measured later than at, e.g. by a banner fetching a longer range.
+func (t *Telemetry) observe(o *observation, value int, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if at.Before(o.at) {
+		return
+	}
+	*o = observation{value: value, at: at}
+}
+
+// observeRequest records the outcome of a request to FitBit. err is nil if it succeeded.
+func (t *Telemetry) observeRequest(err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.apiErrors[apiErrorType(err)]++
+		return
+	}
+	t.lastSuccess = time.Now()
+}
+
+// observeRender records how long generating the banner called name took.
+func (t *Telemetry) observeRender(name string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rs := t.renders[name]
+	rs.count++
+	rs.sum += d
+	t.renders[name] = rs
+}
+
+// writeMetrics writes t and the expiry of creds' API token to w in Prometheus' text exposition format.
+// Measurements that haven't been made yet are left out.
+func (t *Telemetry) writeMetrics(w io.Writer, creds UserCredentials, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	sample := func(name, labels string, v float64) {
+		fmt.Fprintf(bw, "%s%s %s\n", name, labels, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	timestamp := func(t time.Time) float64 {
+		return float64(t.UnixNano()) / float64(time.Second)
+	}
+
+	if !t.heartRate.at.IsZero() {
+		metric("fitbit_heart_rate_bpm", "gauge", "The latest heart rate measured.")
+		sample("fitbit_heart_rate_bpm", "", float64(t.heartRate.value))
+		metric("fitbit_heart_rate_timestamp_seconds", "gauge", "When the latest heart rate was measured, i.e. the data synced from the watch up to.")
+		sample("fitbit_heart_rate_timestamp_seconds", "", timestamp(t.heartRate.at))
+	}
+	if !t.restingHeartRate.at.IsZero() {
+		metric("fitbit_resting_heart_rate_bpm", "gauge", "The resting heart rate of the latest day FitBit has one for.")
+		sample("fitbit_resting_heart_rate_bpm", "", float64(t.restingHeartRate.value))
+	}
+	if at := t.stepsToday.at; !at.IsZero() {
+		steps := t.stepsToday.value
+		if y, m, d := now.In(at.Location()).Date(); at.Year() != y || at.Month() != m || at.Day() != d {
+			steps = 0 // none fetched since midnight
+		}
+		metric("fitbit_steps_today", "gauge", "The steps taken so far today.")
+		sample("fitbit_steps_today", "", float64(steps))
+	}
+	if !t.lastSuccess.IsZero() {
+		metric("fitbit_last_success_timestamp_seconds", "gauge", "When a request to FitBit last succeeded.")
+		sample("fitbit_last_success_timestamp_seconds", "", timestamp(t.lastSuccess))
+	}
+	if !creds.ExpiresAt.IsZero() {
+		metric("fitbit_token_expiry_timestamp_seconds", "gauge", "When the API token expires, unless refreshed first.")
+		sample("fitbit_token_expiry_timestamp_seconds", "", timestamp(creds.ExpiresAt))
+	}
+
+	metric("fitbit_api_errors_total", "counter", "Requests to FitBit that failed, by type of error.")
+	for _, typ := range apiErrorTypes {
+		sample("fitbit_api_errors_total", fmt.Sprintf(`{type=%q}`, typ), float64(t.apiErrors[typ]))
+	}
+
+	names := make([]string, 0, len(t.renders))
+	for name := range t.renders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metric("fitbit_banner_render_duration_seconds", "summary", "Time taken to fetch data for and render each banner.")
+	for _, name := range names {
+		labels := fmt.Sprintf(`{banner=%q}`, name)
+		sample("fitbit_banner_render_duration_seconds_sum", labels, t.renders[name].sum.Seconds())
+		sample("fitbit_banner_render_duration_seconds_count", labels, float64(t.renders[name].count))
+	}
+	return bw.Flush()
+}
+
+// serveMetrics serves metrics for Prometheus to scrape.
+func (s *server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if !s.currentConfig().Metrics.authorize(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := s.client.Telemetry.writeMetrics(w, s.client.credentials(), time.Now()); err != nil {
+		log.Print("Error serving metrics: ", err.Error())
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_apiErrorType(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&ExpiredTokenError{}, "expired_token"},
+		{fmt.Errorf("error after token refresh: %w", &InvalidGrantError{}), "invalid_grant"},
+		{&InsufficientScopeError{}, "insufficient_scope"},
+		{&RateLimitError{}, "rate_limit"},
+		{&ServerError{}, "server"},
+		{&FitbitError{StatusCode: http.StatusBadRequest}, "other"},
+		{fmt.Errorf("Get: %w", context.DeadlineExceeded), "timeout"},
+		{errors.New("connection refused"), "network"},
+	}
+	for _, tt := range tests {
+		if got := apiErrorType(tt.err); got != tt.want {
+			t.Errorf("apiErrorType(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestServer_serveMetrics(t *testing.T) {
+	var rateLimited int32
+	s := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&rateLimited) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, testHeartRateJSON)
+	}))
+	expiry := time.Unix(4102444800, 0) // 2100, so no refresh is attempted
+	s.client.UserCredentials.ExpiresAt = expiry
+	s.config.Metrics = EndpointConfig{Enabled: true, Token: "TOKEN"}
+
+	rec := httptest.NewRecorder()
+	s.serveMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d without the token, want 401", rec.Code)
+	}
+
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Authorization", "Bearer TOKEN")
+		s.serveMetrics(rec, req)
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+			t.Errorf("got content type %q, want Prometheus' text format", ct)
+		}
+		return rec.Body.String()
+	}
+
+	if got := scrape(); strings.Contains(got, "fitbit_heart_rate_bpm") {
+		t.Errorf("expected no heart rate before the first refresh, got\n%s", got)
+	}
+
+	s.refresh(context.Background(), "/stats.svg")
+	atomic.StoreInt32(&rateLimited, 1)
+	s.refresh(context.Background(), "/stats.svg")
+
+	got := scrape()
+	for _, want := range []string{
+		"# TYPE fitbit_heart_rate_bpm gauge\nfitbit_heart_rate_bpm 72\n",
+		"fitbit_token_expiry_timestamp_seconds 4.1024448e+09\n",
+		"fitbit_last_success_timestamp_seconds ",
+		`fitbit_api_errors_total{type="rate_limit"} 1` + "\n",
+		`fitbit_api_errors_total{type="server"} 0` + "\n",
+		`fitbit_banner_render_duration_seconds_count{banner="stats"} 2` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected metrics to contain %q, got\n%s", want, got)
+		}
+	}
+}
+
+func TestServer_metricsNotServedByDefault(t *testing.T) {
+	s := newServer(testBannerConfig())
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want 404 unless metrics are enabled", rec.Code)
+	}
+}
+
+func TestTelemetry_stepsTodayResetAtMidnight(t *testing.T) {
+	tel := newTelemetry()
+	at := time.Date(2021, 3, 6, 23, 50, 0, 0, time.UTC)
+	tel.observeStepsToday(9000, at)
+	tel.observeStepsToday(100, at.Add(-time.Hour)) // older than what's recorded
+
+	for _, tt := range []struct {
+		now  time.Time
+		want string
+	}{
+		{at.Add(5 * time.Minute), "fitbit_steps_today 9000\n"},
+		{at.Add(15 * time.Minute), "fitbit_steps_today 0\n"},
+	} {
+		var b bytes.Buffer
+		if err := tel.writeMetrics(&b, UserCredentials{}, tt.now); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(b.String(), tt.want) {
+			t.Errorf("at %s, expected %q, got\n%s", tt.now.Format("15:04"), tt.want, b.String())
+		}
+	}
+}
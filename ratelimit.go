@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rateLimitWarnAt is the number of remaining requests under which the budget is logged after every response.
+const rateLimitWarnAt = 15
+
+// rateLimitFallbackWait is how long to back off after a 429 that does not say when the budget resets.
+const rateLimitFallbackWait = time.Minute
+
+// RateLimit is FitBit's hourly request budget as reported by the Fitbit-Rate-Limit-* headers of the last response.
+type RateLimit struct {
+	// Limit is the number of requests allowed per hour.
+	Limit int
+	// Remaining is the number of requests left until Reset.
+	Remaining int
+	// Reset is when Remaining goes back to Limit.
+	Reset time.Time
+}
+
+// exhausted reports whether no requests can be made until Reset.
+func (rl RateLimit) exhausted(now time.Time) bool {
+	return !rl.Reset.IsZero() && rl.Remaining <= 0 && now.Before(rl.Reset)
+}
+
+// parseRateLimit reads the Fitbit-Rate-Limit-* headers. ok is false if FitBit did not send them.
+func parseRateLimit(h http.Header, now time.Time) (rl RateLimit, ok bool) {
+	limit, err := strconv.Atoi(h.Get("Fitbit-Rate-Limit-Limit"))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	remaining, err := strconv.Atoi(h.Get("Fitbit-Rate-Limit-Remaining"))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	reset, err := strconv.Atoi(h.Get("Fitbit-Rate-Limit-Reset"))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	return RateLimit{
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     now.Add(time.Duration(reset) * time.Second),
+	}, true
+}
+
+// RateLimit returns the request budget as of the last response from FitBit.
+func (c *FitbitClient) RateLimit() RateLimit {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rateLimit
+}
+
+// checkRateLimit returns a *RateLimitError without contacting FitBit if the request budget is spent.
+func (c *FitbitClient) checkRateLimit() error {
+	rl := c.RateLimit()
+	now := time.Now()
+	if !rl.exhausted(now) {
+		return nil
+	}
+	return &RateLimitError{
+		FitbitError: FitbitError{
+			StatusCode: http.StatusTooManyRequests,
+			Message:    "request budget exhausted, backing off",
+		},
+		RetryAfter: rl.Reset.Sub(now),
+	}
+}
+
+// updateRateLimit records the request budget reported by resp. respErr is the error decoded from resp, if any.
+func (c *FitbitClient) updateRateLimit(resp *http.Response, respErr error) {
+	now := time.Now()
+	rl, ok := parseRateLimit(resp.Header, now)
+	var rlErr *RateLimitError
+	if errors.As(respErr, &rlErr) {
+		wait := rlErr.RetryAfter
+		if wait <= 0 {
+			wait = rateLimitFallbackWait
+		}
+		rl.Remaining = 0
+		rl.Reset = now.Add(wait)
+		ok = true
+	}
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	c.rateLimit = rl
+	c.mu.Unlock()
+
+	if rl.Remaining <= 0 {
+		log.Printf("FitBit rate limit exhausted, backing off until %s", rl.Reset.Format(time.Kitchen))
+	} else if rl.Remaining < rateLimitWarnAt {
+		log.Printf("FitBit rate limit: %d of %d requests remaining until %s", rl.Remaining, rl.Limit, rl.Reset.Format(time.Kitchen))
+	}
+}
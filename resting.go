@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"math"
+	"time"
+)
+
+// RestingConfig configures the /resting.svg banner.
+type RestingConfig struct {
+	// Title is the title at the top of the banner. Defaults to "My Resting Heart Rate (Past N Days)".
+	Title string `json:"title"`
+
+	// Days is how many days to plot e.g., 30, 90 or 365. Defaults to 30. At most 365.
+	Days int `json:"days"`
+}
+
+func (rc RestingConfig) withDefaults() RestingConfig {
+	if rc.Days <= 0 {
+		rc.Days = 30
+	}
+	if rc.Days > maxDateRangeDays {
+		rc.Days = maxDateRangeDays
+	}
+	if rc.Title == "" {
+		rc.Title = fmt.Sprintf("My Resting Heart Rate (Past %d Days)", rc.Days)
+	}
+	return rc
+}
+
+// maxDateRangeDays is the longest range of days FitBit returns heart-rate summaries for in a single request.
+const maxDateRangeDays = 365
+
+// restingHeartRates returns the resting heart rate of each day from days-1 days ago through today.
+// Days FitBit has no resting heart rate for are left out.
+func (c *FitbitClient) restingHeartRates(ctx context.Context, config Config, days int) ([]BannerXY, error) {
+	loc := config.location()
+	now := c.clock().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	xy := make([]BannerXY, 0, days)
+	for _, w := range dateWindows(today.AddDate(0, 0, -(days-1)), today, maxDateRangeDays) {
+		startDate, _ := dateHourMin(w.start)
+		endDate, _ := dateHourMin(w.end)
+		path := fmt.Sprintf(`/1/user/%s/activities/heart/date/%s/%s.json`, c.credentials().UserID, startDate, endDate)
+
+		ts := HeartRateTimeSeries{}
+		if err := c.get(ctx, path, &ts); err != nil {
+			return nil, fmt.Errorf("error grabbing resting heart rate data: %w", err)
+		}
+		for _, day := range ts.ActivitiesHeart {
+			resting := day.restingHeartRate()
+			if resting <= 0 {
+				continue
+			}
+			date, err := time.ParseInLocation("2006-01-02", day.DateTime, loc)
+			if err != nil {
+				continue
+			}
+			xy = append(xy, BannerXY{
+				X: date,
+				Y: resting,
+			})
+		}
+	}
+	if n := len(xy); n > 0 {
+		c.Telemetry.observeRestingHeartRate(xy[n-1].Y, xy[n-1].X)
+	}
+	dataset := make([]Datapoint, 0, len(xy))
+	for _, pt := range xy {
+		dataset = append(dataset, Datapoint{DateTime: pt.X, Value: pt.Y})
+	}
+	if err := c.record(MetricRestingHeartRate, dataset); err != nil {
+		return nil, err
+	}
+	return xy, nil
+}
+
+// dateWindows splits the days from start through end into windows of at most maxDays days each.
+func dateWindows(start, end time.Time, maxDays int) []timeWindow {
+	windows := make([]timeWindow, 0)
+	for cur := start; !cur.After(end); cur = cur.AddDate(0, 0, maxDays) {
+		windowEnd := cur.AddDate(0, 0, maxDays-1)
+		if windowEnd.After(end) {
+			windowEnd = end
+		}
+		windows = append(windows, timeWindow{cur, windowEnd})
+	}
+	return windows
+}
+
+func updateRestingSVG(ctx context.Context, client *FitbitClient, c Config) (string, error) {
+	days := c.Resting.withDefaults().Days
+	xy, err := client.restingHeartRates(ctx, c, days*2) // the previous period is needed for the delta
+	if err != nil {
+		return "", bannerError("grabbing resting heart rates", err)
+	}
+	banner, err := genRestingBanner(xy, c)
+	if err != nil {
+		return "", bannerError("generating resting heart rate banner", err)
+	}
+	return banner, nil
+}
+
+// genRestingBanner plots resting heart rates over the configured period with a trend line,
+// with the period's average and its change from the period before on the left.
+// xy holds both periods; only the latest is plotted.
+func genRestingBanner(xy []BannerXY, config Config) (string, error) {
+	restingConfig := config.Resting.withDefaults()
+	loc := config.location()
+	now := time.Now().In(loc)
+	periodStart := time.Date(now.Year(), now.Month(), now.Day()-(restingConfig.Days-1), 0, 0, 0, 0, loc)
+
+	current, previous := splitPeriod(xy, periodStart)
+	timeSeries := plotXYs(current)
+	if len(timeSeries) <= 0 {
+		return defaultBanner(config), fmt.Errorf("data set empty")
+	}
+
+	thirdWidth := config.BannerWidth / 3 // summary takes up 1/3rd, plot 2/3rd
+	plotWidth := thirdWidth * 2
+
+	avg := meanY(current)
+	change := "no previous data"
+	if len(previous) > 0 {
+		change = fmt.Sprintf("%s vs previous %d days", signedBPM(avg-meanY(previous)), restingConfig.Days)
+	}
+
+	p := newPlot(config)
+	p.X.Tick.Marker = plot.TimeTicks{
+		Ticker: dayTicker(loc),
+		Format: "Jan 2",
+		Time: func(t float64) time.Time {
+			return time.Unix(int64(t), 0).In(loc)
+		},
+	}
+	line, err := plotter.NewLine(timeSeries)
+	if err != nil {
+		return "", err
+	}
+	line.Color = RGBAFromString(config.Theme.PlotLine)
+	p.Add(line)
+	if len(timeSeries) > 1 {
+		trend, err := trendLine(timeSeries, config.Theme.Axes)
+		if err != nil {
+			return "", err
+		}
+		p.Add(trend)
+	}
+
+	tData := newTemplate(config, restingConfig.Title)
+	tData.Plot = renderPlot(p, plotWidth, config)
+	tData.Icon = genSummaryText(fmt.Sprintf("%d day average", restingConfig.Days), change, thirdWidth, config.BannerHeight, config.Theme.CurrentBPM)
+	tData.Value = fmt.Sprintf("%.0f", avg)
+	tData.ValueColor = config.Theme.CurrentBPM
+	tData.Caption = "Resting BPM"
+	return execTemplate(tData)
+}
+
+// meanY returns the average Y of xy.
+func meanY(xy []BannerXY) float64 {
+	sum := 0
+	for _, pt := range xy {
+		sum += pt.Y
+	}
+	return float64(sum) / float64(len(xy))
+}
+
+// signedBPM formats a change in BPM with an arrow showing its direction e.g., ▼ 2.3 BPM.
+func signedBPM(delta float64) string {
+	arrow := "▲"
+	if delta < 0 {
+		arrow = "▼"
+	}
+	return fmt.Sprintf("%s %.1f BPM", arrow, math.Abs(delta))
+}
+
+// linearFit returns the slope and intercept of the least squares line through timeSeries.
+func linearFit(timeSeries plotter.XYs) (slope, intercept float64) {
+	n := float64(len(timeSeries))
+	var sumX, sumY, sumXY, sumXX float64
+	for _, pt := range timeSeries {
+		sumX += pt.X
+		sumY += pt.Y
+		sumXY += pt.X * pt.Y
+		sumXX += pt.X * pt.X
+	}
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return 0, sumY / n
+	}
+	slope = (n*sumXY - sumX*sumY) / denom
+	return slope, (sumY - slope*sumX) / n
+}
+
+// trendLine returns a dashed least squares line through timeSeries, spanning it.
+func trendLine(timeSeries plotter.XYs, lineColor string) (plot.Plotter, error) {
+	// Fit relative to the first point so squaring unix times does not lose precision.
+	x0 := timeSeries[0].X
+	shifted := make(plotter.XYs, len(timeSeries))
+	for i, pt := range timeSeries {
+		shifted[i] = plotter.XY{X: pt.X - x0, Y: pt.Y}
+	}
+	slope, intercept := linearFit(shifted)
+	xmin, xmax, _, _ := plotter.XYRange(timeSeries)
+	line, err := plotter.NewLine(plotter.XYs{
+		{X: xmin, Y: intercept + slope*(xmin-x0)},
+		{X: xmax, Y: intercept + slope*(xmax-x0)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	line.Color = RGBAFromString(lineColor)
+	line.Dashes = []vg.Length{4, 4}
+	return line, nil
+}
+
+// dayTicker returns a ticker marking local midnights, labeling about six of them.
+func dayTicker(loc *time.Location) plot.TickerFunc {
+	return func(min, max float64) []plot.Tick {
+		first := time.Unix(int64(min), 0).In(loc)
+		day := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, loc)
+		if day.Unix() < int64(min) {
+			day = day.AddDate(0, 0, 1)
+		}
+		days := int((max-min)/86400) + 1
+		labelEvery := int(math.Ceil(float64(days) / 6))
+		minor := days <= 90 // daily minor ticks would crowd longer ranges
+
+		ticks := make([]plot.Tick, 0)
+		for i := 0; float64(day.Unix()) <= max; i, day = i+1, day.AddDate(0, 0, 1) {
+			if i%labelEvery == 0 {
+				ticks = append(ticks, plot.Tick{Value: float64(day.Unix()), Label: "Jan 2"})
+			} else if minor {
+				ticks = append(ticks, plot.Tick{Value: float64(day.Unix())})
+			}
+		}
+		return ticks
+	}
+}
+
+// splitPeriod splits xy into the datapoints from start onwards and the ones before it.
+func splitPeriod(xy []BannerXY, start time.Time) (current, previous []BannerXY) {
+	current, previous = make([]BannerXY, 0, len(xy)), make([]BannerXY, 0, len(xy))
+	for _, pt := range xy {
+		if pt.X.Before(start) {
+			previous = append(previous, pt)
+		} else {
+			current = append(current, pt)
+		}
+	}
+	return current, previous
+}
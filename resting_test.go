@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+func TestHeartRateDay_restingHeartRate(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want int
+	}{
+		{"date range", `{"dateTime":"2021-03-01","value":{"heartRateZones":[],"restingHeartRate":61}}`, 61},
+		{"intraday", `{"dateTime":"2021-03-01","heartRateZones":[],"restingHeartRate":58,"value":"64.2"}`, 58},
+		{"no data", `{"dateTime":"2021-03-01","value":{"heartRateZones":[]}}`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var day HeartRateDay
+			if err := json.Unmarshal([]byte(tt.json), &day); err != nil {
+				t.Fatal(err)
+			}
+			if got := day.restingHeartRate(); got != tt.want {
+				t.Errorf("restingHeartRate() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dateWindows(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		days int
+		want []string
+	}{
+		{"one window", 30, []string{"2020-01-01/2020-01-30"}},
+		{"split", 400, []string{"2020-01-01/2020-12-30", "2020-12-31/2021-02-03"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			end := start.AddDate(0, 0, tt.days-1)
+			got := make([]string, 0)
+			for _, w := range dateWindows(start, end, maxDateRangeDays) {
+				s, _ := dateHourMin(w.start)
+				e, _ := dateHourMin(w.end)
+				got = append(got, s+"/"+e)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("dateWindows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_linearFit(t *testing.T) {
+	slope, intercept := linearFit(plotter.XYs{{X: 0, Y: 1}, {X: 1, Y: 3}, {X: 2, Y: 5}})
+	if slope != 2 || intercept != 1 {
+		t.Errorf("linearFit() = %v, %v, want 2, 1", slope, intercept)
+	}
+	slope, intercept = linearFit(plotter.XYs{{X: 5, Y: 60}})
+	if slope != 0 || intercept != 60 {
+		t.Errorf("linearFit() of one point = %v, %v, want 0, 60", slope, intercept)
+	}
+}
+
+func TestFitbitClient_restingHeartRates(t *testing.T) {
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/1min/") {
+			t.Errorf("unexpected intraday request to %s", r.URL.Path)
+		}
+		fmt.Fprint(w, `{"activities-heart":[
+			{"dateTime":"2021-03-01","value":{"restingHeartRate":62}},
+			{"dateTime":"2021-03-02","value":{}},
+			{"dateTime":"2021-03-03","value":{"restingHeartRate":60}}]}`)
+	}))
+	xy, err := c.restingHeartRates(context.Background(), Config{TimezoneName: "UTC"}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(xy) != 2 || xy[0].Y != 62 || xy[1].Y != 60 {
+		t.Fatalf("got %v, want 62 and 60, skipping the day without data", xy)
+	}
+	if want := time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC); !xy[1].X.Equal(want) {
+		t.Errorf("got date %v, want %v", xy[1].X, want)
+	}
+}
+
+func Test_genRestingBanner(t *testing.T) {
+	config := testBannerConfig()
+	config.TimezoneName = "UTC"
+	config.Resting.Days = 3
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	xy := make([]BannerXY, 0)
+	for i := 5; i >= 0; i-- {
+		y := 60
+		if i >= 3 {
+			y = 63 // previous period
+		}
+		xy = append(xy, BannerXY{X: today.AddDate(0, 0, -i), Y: y})
+	}
+
+	banner, err := genRestingBanner(xy, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{">60<", "▼ 3.0 BPM vs previous 3 days", "3 day average", "Past 3 Days"} {
+		if !strings.Contains(banner, want) {
+			t.Errorf("banner is missing %q", want)
+		}
+	}
+}
+
+func Test_splitPeriod(t *testing.T) {
+	start := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	xy := make([]BannerXY, 0)
+	for i, y := range []int{63, 64, 62, 60, 61, 59} {
+		xy = append(xy, BannerXY{X: start.AddDate(0, 0, i-3), Y: y})
+	}
+	current, previous := splitPeriod(xy, start)
+	if len(current) != 3 || len(previous) != 3 || !current[0].X.Equal(start) {
+		t.Fatalf("got current %v previous %v, want 3 days each split at %v", current, previous, start)
+	}
+	if avg, change := meanY(current), meanY(current)-meanY(previous); avg != 60 || change != -3 {
+		t.Errorf("got average %v changed by %v, want 60 down 3", avg, change)
+	}
+}
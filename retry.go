@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// RetryPolicy controls how requests to FitBit that fail for transient reasons are retried.
+// Zero fields, and Jitter when unset, take the value in defaultRetryPolicy.
+type RetryPolicy struct {
+	// MaxAttempts is how many times a request is made before giving up, including the first attempt.
+	MaxAttempts int `json:"max_attempts"`
+
+	// BaseDelay is how long (in milliseconds) to wait before the first retry. Each retry after waits twice as long.
+	BaseDelay int `json:"base_delay_ms"`
+
+	// MaxDelay is the longest (in milliseconds) to wait between two attempts.
+	MaxDelay int `json:"max_delay_ms"`
+
+	// Jitter is the fraction (0 to 1) of each delay that is randomized, so retries from several requests spread out.
+	// 0 turns jitter off.
+	Jitter *float64 `json:"jitter,omitempty"`
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500,
+	MaxDelay:    8000,
+	Jitter:      jitter(0.5),
+}
+
+// jitter returns a pointer to f, for setting RetryPolicy.Jitter.
+func jitter(f float64) *float64 {
+	return &f
+}
+
+// withDefaults fills in zero fields and an unset or out of range Jitter of p from defaultRetryPolicy.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = defaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = defaultRetryPolicy.MaxDelay
+	}
+	if p.Jitter == nil || *p.Jitter < 0 || *p.Jitter > 1 {
+		p.Jitter = defaultRetryPolicy.Jitter
+	}
+	return p
+}
+
+// delay returns how long to wait after the given failed attempt (starting at 1). p must have its defaults filled in.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := time.Duration(p.BaseDelay) * time.Millisecond
+	max := time.Duration(p.MaxDelay) * time.Millisecond
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d - time.Duration(*p.Jitter*rand.Float64()*float64(d))
+}
+
+// retryable reports whether err is worth making the same request again for.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var srvErr *ServerError
+	if errors.As(err, &srvErr) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retry calls attempt until it succeeds, returns an error that is not retryable, the policy's attempts run out,
+// or ctx is done.
+func (p RetryPolicy) retry(ctx context.Context, attempt func() error) error {
+	p = p.withDefaults()
+	var err error
+	for i := 1; ; i++ {
+		err = attempt()
+		if err == nil || !retryable(err) || i >= p.MaxAttempts {
+			return err
+		}
+
+		wait := p.delay(i)
+		var srvErr *ServerError
+		if errors.As(err, &srvErr) && srvErr.RetryAfter > wait {
+			wait = srvErr.RetryAfter
+		}
+		if max := time.Duration(p.MaxDelay) * time.Millisecond; wait > max {
+			wait = max
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_delay(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, BaseDelay: 100, MaxDelay: 1000, Jitter: jitter(0.5)}
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{5, 500 * time.Millisecond, 1000 * time.Millisecond},
+		{40, 500 * time.Millisecond, 1000 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if got := p.delay(tt.attempt); got < tt.min || got > tt.max {
+				t.Errorf("delay(%d) = %s, want between %s and %s", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestRetryPolicy_withDefaults(t *testing.T) {
+	tests := []struct {
+		jitter *float64
+		want   float64
+	}{
+		{nil, 0.5},
+		{jitter(0), 0},
+		{jitter(0.2), 0.2},
+		{jitter(-1), 0.5},
+		{jitter(2), 0.5},
+	}
+	for _, tt := range tests {
+		p := RetryPolicy{Jitter: tt.jitter}.withDefaults()
+		if *p.Jitter != tt.want {
+			t.Errorf("jitter %v: got %v, want %v", tt.jitter, *p.Jitter, tt.want)
+		}
+	}
+
+	p := RetryPolicy{BaseDelay: 100, Jitter: jitter(0)}.withDefaults()
+	if got := p.delay(2); got != 200*time.Millisecond {
+		t.Errorf("delay(2) = %s without jitter, want exactly 200ms", got)
+	}
+}
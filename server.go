@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bannerGenerator fetches data from FitBit and renders a banner from it.
+type bannerGenerator func(ctx context.Context, client *FitbitClient, c Config) (string, error)
+
+// bannerGenerators maps the name of each banner, as listed in the banners config field, to its generator.
+// A banner named NAME is served at /NAME.svg.
+var bannerGenerators = map[string]bannerGenerator{
+	"stats":     updateSVG,
+	"steps":     updateStepsSVG,
+	"sleep":     updateSleepSVG,
+	"resting":   updateRestingSVG,
+	"spo2":      updateSpO2SVG,
+	"hrv":       updateHRVSVG,
+	"azm":       updateAZMSVG,
+	"dashboard": updateDashboardSVG,
+}
+
+// dailyBanners are the banners of data FitBit summarizes per day or night. Refreshing them more often than
+// dailyRefreshInterval would spend requests from FitBit's hourly rate limit on data that rarely changes.
+var dailyBanners = map[string]bool{
+	"sleep":   true,
+	"resting": true,
+	"spo2":    true,
+	"hrv":     true,
+	"azm":     true,
+}
+
+const dailyRefreshInterval = time.Hour
+
+// minRefreshInterval keeps a missing or tiny cache_invalidation_time from regenerating banners back to back.
+const minRefreshInterval = time.Minute
+
+// server serves banners from memory and regenerates them in the background, so serving them never waits on FitBit.
+// It is safe for concurrent use.
+type server struct {
+	client *FitbitClient
+
+	// saveConfig persists the config after the API token is refreshed.
+	saveConfig func(Config) error
+
+	mu     sync.RWMutex // guards config and the banner and nextRefresh fields of each banner
+	config Config
+
+	// banners holds each enabled banner keyed by the path it is served at e.g., /stats.svg.
+	banners map[string]*bannerState
+}
+
+// bannerState is the last banner generated for a path and when it is due to be regenerated.
+type bannerState struct {
+	name        string
+	gen         bannerGenerator
+	banner      string    // the last banner generated successfully, or the default banner
+	nextRefresh time.Time // when the banner is due to be regenerated
+
+	inflightMu sync.Mutex
+	inflight   *refreshCall // the refresh currently running, if any
+}
+
+// refreshCall is a refresh shared by every caller that asked for one while it was running.
+type refreshCall struct {
+	done chan struct{}
+	wait time.Duration // set before done is closed
+}
+
+func newServer(config Config) *server {
+	s := &server{
+		client:     newClient(config),
+		saveConfig: writeConfigFile,
+		config:     config,
+		banners:    make(map[string]*bannerState),
+	}
+	for _, name := range config.enabledBanners() {
+		s.banners["/"+name+".svg"] = &bannerState{
+			name:   name,
+			gen:    bannerGenerators[name],
+			banner: defaultBanner(config),
+		}
+	}
+	s.client.OnTokenRefresh = s.saveCredentials
+	s.client.Telemetry = newTelemetry()
+	return s
+}
+
+// paths returns the paths banners are served at, sorted.
+func (s *server) paths() []string {
+	paths := make([]string, 0, len(s.banners))
+	for path := range s.banners {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// handler routes requests for each banner to the server, and to the endpoints serving more than banners
+// that the config enables.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	for path := range s.banners {
+		mux.Handle(path, s)
+	}
+	config := s.currentConfig()
+	if config.Export.Enabled && s.client.Store != nil {
+		mux.HandleFunc("/export", s.serveExport)
+	}
+	if config.Metrics.Enabled {
+		mux.HandleFunc("/metrics", s.serveMetrics)
+	}
+	return mux
+}
+
+// EndpointConfig opts in to an endpoint serving more than banners, which anyone who can reach the port could
+// otherwise read.
+type EndpointConfig struct {
+	// Enabled serves the endpoint.
+	Enabled bool `json:"enabled"`
+
+	// Token must be sent with each request as "Authorization: Bearer TOKEN". Required when enabled.
+	Token string `json:"token"`
+}
+
+// authorize reports whether r carries ec's bearer token, replying 401 Unauthorized if it doesn't.
+func (ec EndpointConfig) authorize(w http.ResponseWriter, r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if ec.Token == "" || !strings.HasPrefix(auth, "Bearer ") ||
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(ec.Token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "missing or wrong bearer token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// saveCredentials stores refreshed credentials in the config and persists it.
+func (s *server) saveCredentials(userCreds UserCredentials) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.UserCredentials = userCreds
+	return s.saveConfig(s.config)
+}
+
+// currentConfig returns a copy of the config.
+func (s *server) currentConfig() Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+// current returns the most recently generated banner at path, or the default banner if none has been generated yet.
+func (s *server) current(path string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.banners[path].banner
+}
+
+// ServeHTTP serves the banner at the request's path from memory. If it is overdue for regeneration,
+// e.g. after the host slept, a refresh is started in the background.
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, ok := s.banners[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.RLock()
+	banner, overdue := b.banner, time.Now().After(b.nextRefresh)
+	s.mu.RUnlock()
+	if overdue {
+		go s.refresh(context.Background(), r.URL.Path)
+	}
+	w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store, no-cache, max-age=0")
+	fmt.Fprint(w, banner)
+}
+
+// run refreshes each banner on its refresh interval until ctx is done.
+func (s *server) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for path := range s.banners {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			s.runBanner(ctx, path)
+		}(path)
+	}
+	wg.Wait()
+}
+
+func (s *server) runBanner(ctx context.Context, path string) {
+	for {
+		wait := s.refresh(ctx, path)
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// refresh fetches new data and swaps in a newly generated banner at path, returning how long to wait before
+// the next refresh. On failure the last banner is kept. Callers arriving while a refresh of the same banner
+// is running wait for it instead of starting another.
+func (s *server) refresh(ctx context.Context, path string) time.Duration {
+	b := s.banners[path]
+	b.inflightMu.Lock()
+	if call := b.inflight; call != nil {
+		b.inflightMu.Unlock()
+		select {
+		case <-call.done:
+			return call.wait
+		case <-ctx.Done():
+			return 0
+		}
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	b.inflight = call
+	b.inflightMu.Unlock()
+
+	call.wait = s.doRefresh(ctx, b)
+
+	b.inflightMu.Lock()
+	b.inflight = nil
+	b.inflightMu.Unlock()
+	close(call.done)
+	return call.wait
+}
+
+func (s *server) doRefresh(ctx context.Context, b *bannerState) time.Duration {
+	config := s.currentConfig()
+	wait := config.refreshInterval(b.name)
+	ctx, cancel := context.WithTimeout(ctx, config.requestTimeout())
+	defer cancel()
+
+	start := time.Now()
+	banner, err := b.gen(ctx, s.client, config)
+	s.client.Telemetry.observeRender(b.name, time.Since(start))
+	if err != nil {
+		var rlErr *RateLimitError
+		if errors.As(err, &rlErr) && rlErr.RetryAfter > wait {
+			log.Print("Waiting for the FitBit rate limit to reset before refreshing again in ", rlErr.RetryAfter.Round(time.Second))
+			wait = rlErr.RetryAfter
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		b.banner = banner
+	}
+	b.nextRefresh = time.Now().Add(wait)
+	return wait
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testBannerConfig() Config {
+	return Config{
+		PlotRange:             4,
+		CacheInvalidationTime: 180,
+		BannerWidth:           500,
+		BannerHeight:          100,
+		BannerTitle:           "test banner",
+		Theme: Theme{
+			Background: "rgba(50, 35, 35, 255)",
+			Axes:       "rgba(239, 93, 50, 255)",
+			TextTicks:  "rgba(230, 225, 196, 255)",
+			PlotLine:   "rgba(239, 172, 50, 255)",
+			Heart:      "rgba(239, 172, 50, 255)",
+		},
+		UserCredentials: UserCredentials{APIToken: "token-1", RefreshToken: "refresh-0", UserID: "USER", Scope: "heartrate"},
+	}
+}
+
+// newTestServer returns a server fetching from h that never writes config.json.
+func newTestServer(t *testing.T, h http.Handler) *server {
+	fake := httptest.NewServer(h)
+	t.Cleanup(fake.Close)
+	config := testBannerConfig()
+	config.APIBaseURL = fake.URL
+	s := newServer(config)
+	s.saveConfig = func(Config) error { return nil }
+	return s
+}
+
+const testHeartRateJSON = `{"activities-heart-intraday":{"dataset":[{"time":"00:00:00","value":60},{"time":"00:01:00","value":72}]}}`
+
+func TestServer_refresh(t *testing.T) {
+	var fail int32
+	s := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, testHeartRateJSON)
+	}))
+	if s.current("/stats.svg") != defaultBanner(s.config) {
+		t.Error("expected the default banner before the first refresh")
+	}
+
+	if wait := s.refresh(context.Background(), "/stats.svg"); wait != 180*time.Second {
+		t.Errorf("refresh() = %s, want the cache invalidation time", wait)
+	}
+	generated := s.current("/stats.svg")
+	if !strings.Contains(generated, "test banner") || !strings.Contains(generated, ">72<") {
+		t.Error("expected a generated banner after a successful refresh")
+	}
+
+	atomic.StoreInt32(&fail, 1)
+	s.refresh(context.Background(), "/stats.svg")
+	if s.current("/stats.svg") != generated {
+		t.Error("expected the last banner to be kept after a failed refresh")
+	}
+}
+
+func TestServer_waitsForRateLimit(t *testing.T) {
+	s := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Fitbit-Rate-Limit-Reset", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	if wait := s.refresh(context.Background(), "/stats.svg"); wait != time.Hour {
+		t.Errorf("refresh() = %s, want to wait for the rate limit reset", wait)
+	}
+}
+
+func TestConfig_refreshInterval(t *testing.T) {
+	config := Config{CacheInvalidationTime: 180, RefreshIntervals: map[string]int{"steps": 600}}
+	tests := []struct {
+		name string
+		want time.Duration
+	}{
+		{"stats", 3 * time.Minute},
+		{"steps", 10 * time.Minute},
+		{"sleep", time.Hour},
+		{"azm", time.Hour},
+		{"hrv", time.Hour},
+		{"spo2", time.Hour},
+		{"resting", time.Hour},
+	}
+	for _, tt := range tests {
+		if got := config.refreshInterval(tt.name); got != tt.want {
+			t.Errorf("refreshInterval(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+	config.CacheInvalidationTime = 7200
+	if got := config.refreshInterval("sleep"); got != 2*time.Hour {
+		t.Errorf("refreshInterval(\"sleep\") = %s, want no more often than cache_invalidation_time", got)
+	}
+
+	for _, unset := range []Config{{}, {CacheInvalidationTime: -5}, {RefreshIntervals: map[string]int{"stats": 1}}} {
+		if got := unset.refreshInterval("stats"); got != time.Minute {
+			t.Errorf("refreshInterval(\"stats\") = %s with cache_invalidation_time %d and refresh_intervals %v, want 1m0s",
+				got, unset.CacheInvalidationTime, unset.RefreshIntervals)
+		}
+	}
+}
+
+func TestServer_collapsesConcurrentRefreshes(t *testing.T) {
+	s := newTestServer(t, http.NotFoundHandler())
+	var generated int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s.banners["/stats.svg"].gen = func(ctx context.Context, client *FitbitClient, c Config) (string, error) {
+		if atomic.AddInt32(&generated, 1) == 1 {
+			close(started)
+			<-release
+		}
+		return "<svg/>", nil
+	}
+
+	first := make(chan time.Duration)
+	go func() { first <- s.refresh(context.Background(), "/stats.svg") }()
+	<-started
+	// The first refresh is in flight until release is closed, so these find it instead of generating again.
+	// Their contexts are already done, so they return without waiting for it.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 10; i++ {
+		if wait := s.refresh(ctx, "/stats.svg"); wait != 0 {
+			t.Errorf("got wait %s from a refresh collapsed into one in flight, want 0 once its context is done", wait)
+		}
+	}
+	close(release)
+
+	if wait := <-first; wait != 180*time.Second {
+		t.Errorf("got wait %s, want 180s", wait)
+	}
+	if n := atomic.LoadInt32(&generated); n != 1 {
+		t.Errorf("generated the banner %d times, want 1", n)
+	}
+	if got := s.current("/stats.svg"); got != "<svg/>" {
+		t.Errorf("got banner %q, want the one generated", got)
+	}
+}
+
+func TestServer_parallelLoad(t *testing.T) {
+	s := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth2/token" {
+			fmt.Fprint(w, `{"access_token":"token-2","refresh_token":"refresh-1","scope":"heartrate","user_id":"USER","expires_in":28800}`)
+			return
+		}
+		fmt.Fprint(w, testHeartRateJSON)
+	}))
+	s.client.UserCredentials.ExpiresAt = time.Now() // forces a token refresh mid-load
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.run(ctx)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				resp, err := srv.Client().Get(srv.URL + "/stats.svg")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				b, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if !strings.HasPrefix(strings.TrimSpace(string(b)), "<svg") {
+					t.Errorf("got %q, want an SVG", b)
+				}
+				s.refresh(context.Background(), "/stats.svg")
+			}
+		}()
+	}
+	wg.Wait()
+	if got := s.currentConfig().UserCredentials.APIToken; got != "token-2" {
+		t.Errorf("config has API token %q after refresh, want token-2", got)
+	}
+}
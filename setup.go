@@ -7,8 +7,10 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,6 +19,14 @@ type Config struct {
 	// Port is the port to serve the SVG on.
 	Port int `json:"port"`
 
+	// TimezoneName is the IANA name of the timezone used for display and calculations e.g., America/Chicago.
+	// Handles DST and fractional offsets. When empty, Timezone and TimezoneAbbreviation are used instead.
+	// An unknown name fails validation.
+	TimezoneName string `json:"timezone_name"`
+
+	// loc is TimezoneName's location, loaded when the config is validated.
+	loc *time.Location
+
 	// Timezone is the timezone used for display and calculations. Will get a "no data available" error if different from tz on the FitBit app. https://en.wikipedia.org/wiki/List_of_UTC_time_offsets
 	Timezone int `json:"timezone"`
 
@@ -27,17 +37,72 @@ type Config struct {
 	BannerTitle string `json:"banner_title"`
 
 	// CacheInvalidationTime is how long (in seconds) before new heart-rate data should be requested from FitBit's server to make the plot.
+	// The banner is regenerated in the background on this schedule, at most once a minute.
 	CacheInvalidationTime int `json:"cache_invalidation_time"`
 
 	// PlotRange is the time interval (in hours) to look back for heart-rate data.
+	// Ranges over 24 hours are fetched with one request per day.
 	PlotRange int `json:"plot_range"`
 
+	// DetailLevel is the interval between heart-rate datapoints requested from FitBit: 1sec, 1min, 5min or 15min. Defaults to 1min.
+	DetailLevel string `json:"detail_level"`
+
+	// Gaps controls how stretches without heart-rate data are plotted.
+	Gaps GapConfig `json:"gaps"`
+
+	// Store is the path of the file fetched heart-rate data is kept in between refreshes, so only new data is fetched.
+	// When empty, nothing is kept and the whole PlotRange is fetched on each refresh.
+	Store string `json:"store"`
+
+	// Export serves the data in the store at /export. Off by default.
+	Export EndpointConfig `json:"export"`
+
+	// Metrics serves metrics for Prometheus at /metrics. Off by default.
+	Metrics EndpointConfig `json:"metrics"`
+
+	// Sinks lists where datapoints are forwarded to the first time they are fetched, e.g. a time-series database.
+	Sinks []SinkConfig `json:"sinks"`
+
 	// BannerWidth is the width of the generated .SVG.
 	BannerWidth int `json:"banner_width"`
 
 	// BannerHeight is the height of the generated .SVG.
 	BannerHeight int `json:"banner_height"`
 
+	// RequestTimeout is how long (in seconds) fetching new data from FitBit's servers may take before giving up, retries included.
+	RequestTimeout int `json:"request_timeout"`
+
+	// Retry controls how requests to FitBit's servers that fail for transient reasons (network errors, 5xx responses) are retried.
+	Retry RetryPolicy `json:"retry"`
+
+	// Banners lists the banners to serve, each at /NAME.svg: stats (heart rate), steps, sleep, resting, spo2, hrv, azm and dashboard. Defaults to stats only.
+	Banners []string `json:"banners"`
+
+	// RefreshIntervals sets how long (in seconds) to wait between regenerating each banner, by name, e.g. {"sleep": 86400}.
+	// Banners of data FitBit summarizes per day (sleep, resting, spo2, hrv and azm) default to hourly, the others to CacheInvalidationTime.
+	RefreshIntervals map[string]int `json:"refresh_intervals,omitempty"`
+
+	// Steps configures the steps banner.
+	Steps StepsConfig `json:"steps"`
+
+	// Sleep configures the sleep banner.
+	Sleep SleepConfig `json:"sleep"`
+
+	// Resting configures the resting heart rate banner.
+	Resting RestingConfig `json:"resting"`
+
+	// SpO2 configures the SpO2 and breathing rate banner.
+	SpO2 SpO2Config `json:"spo2"`
+
+	// HRV configures the heart rate variability banner.
+	HRV HRVConfig `json:"hrv"`
+
+	// AZM configures the Active Zone Minutes banner.
+	AZM AZMConfig `json:"azm"`
+
+	// Dashboard configures the dashboard banner, which arranges panels from the other banners in a grid.
+	Dashboard DashboardConfig `json:"dashboard"`
+
 	// DisplayViewOnGitHub when true displays watermark/link to the GitHub repo in the top left.
 	DisplayViewOnGitHub bool `json:"display_view_on_github"`
 
@@ -49,6 +114,9 @@ type Config struct {
 
 	// UserCredentials holds credentials to authenticate with and request from the FitBit Web API.
 	UserCredentials UserCredentials `json:"user_credentials"`
+
+	// APIBaseURL overrides the root of FitBit's Web API, e.g. to point at a local fake server. Defaults to https://api.fitbit.com.
+	APIBaseURL string `json:"api_base_url,omitempty"`
 }
 
 // AppCredentials holds generated fields when a new app is made at https://dev.fitbit.com/.
@@ -67,6 +135,16 @@ type UserCredentials struct {
 	Scope string `json:"scope"`
 	// UserID is the FitBit user ID.
 	UserID string `json:"user_id"`
+	// ExpiresAt is when APIToken expires. Zero if unknown, in which case the token is refreshed only after FitBit rejects it.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// needsRefresh reports whether the API token expires within tokenRefreshMargin of now.
+func (uc UserCredentials) needsRefresh(now time.Time) bool {
+	if uc.ExpiresAt.IsZero() {
+		return false
+	}
+	return now.Add(tokenRefreshMargin).After(uc.ExpiresAt)
 }
 
 func setupProcess() {
@@ -84,11 +162,25 @@ func setupProcess() {
 	abbrev, offset := time.Now().Local().Zone()
 	config := Config{
 		Port:                  8090,
+		TimezoneName:          localTimezoneName(),
 		Timezone:              offset / 3600,
 		TimezoneAbbreviation:  abbrev,
 		BannerTitle:           "My Heart Rate From My FitBit Watch (Past 4 Hours)",
 		CacheInvalidationTime: 180,
 		PlotRange:             4,
+		DetailLevel:           "1min",
+		Gaps:                  GapConfig{}.withDefaults(),
+		Store:                 "data.jsonl",
+		RequestTimeout:        20,
+		Banners:               []string{"stats"},
+		Steps:                 StepsConfig{}.withDefaults(),
+		Sleep:                 SleepConfig{}.withDefaults(),
+		Resting:               RestingConfig{}.withDefaults(),
+		SpO2:                  SpO2Config{}.withDefaults(),
+		HRV:                   HRVConfig{}.withDefaults(),
+		AZM:                   AZMConfig{}.withDefaults(),
+		Dashboard:             DashboardConfig{}.withDefaults(),
+		Retry:                 defaultRetryPolicy,
 		Theme: Theme{
 			Background:   "rgba(50, 35, 35, 255)",
 			HeartNumber:  "rgba(50, 35, 35, 255)",
@@ -100,6 +192,17 @@ func setupProcess() {
 			Axes:         "rgba(239, 93, 50, 255)",
 			PlotLine:     "rgba(239, 172, 50, 255)",
 			Heart:        "rgba(239, 172, 50, 255)",
+			ZoneFatBurn:  "rgba(239, 172, 50, 30)",
+			ZoneCardio:   "rgba(239, 93, 50, 45)",
+			ZonePeak:     "rgba(239, 50, 50, 60)",
+			SleepDeep:    "rgba(142, 82, 52, 255)",
+			SleepLight:   "rgba(196, 138, 80, 255)",
+			SleepREM:     "rgba(239, 172, 50, 255)",
+			SleepWake:    "rgba(239, 93, 50, 255)",
+			SpO2Band:     "rgba(239, 172, 50, 60)",
+			AZMFatBurn:   "rgba(239, 172, 50, 255)",
+			AZMCardio:    "rgba(239, 93, 50, 255)",
+			AZMPeak:      "rgba(239, 50, 50, 255)",
 		},
 		BannerWidth:         500,
 		BannerHeight:        100,
@@ -172,6 +275,7 @@ func askUserCredentials(appCreds AppCredentials) UserCredentials {
 	fmt.Println("Step 2. Getting User Credentials")
 	fmt.Println("Follow this link (leave this binary running): ", tokensLink(appCreds.OAuthClientID))
 
+	client := NewFitbitClient(appCreds, UserCredentials{})
 	userCreds := UserCredentials{}
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		userAuthCode := r.URL.Query().Get("code")
@@ -179,10 +283,13 @@ func askUserCredentials(appCreds AppCredentials) UserCredentials {
 			return // occurs when user leaves browser open and gets sent to this link again
 		}
 		var err error
-		userCreds, err = reqInitUserCredentials(userAuthCode, appCreds)
+		userCreds, err = client.reqInitUserCredentials(r.Context(), userAuthCode)
 		if err != nil {
 			fmt.Fprint(w, "Error encountered. See console for further instructions.")
-			fmt.Println(w, "Error requesting user credentials", err)
+			fmt.Println("Error requesting user credentials:", err)
+			if hint := setupHint(err); hint != "" {
+				fmt.Println(hint)
+			}
 			pressEnterToExit()
 		}
 		fmt.Fprint(w, "Setup complete! See console for further instructions.")
@@ -201,12 +308,16 @@ func askUserCredentials(appCreds AppCredentials) UserCredentials {
 		fmt.Println("Error validating user credentials:", err)
 		pressEnterToExit()
 	}
+	if missing := missingScopes(userCreds.Scope, requestedScopes); len(missing) > 0 {
+		fmt.Println("Permission was not given for:", strings.Join(missing, ", ")+". Banners using that data will not be available.")
+	}
 	return userCreds
 }
 
 // tokensLink returns the link used to authorize us access to the user's data.
 func tokensLink(oauthClientID string) string {
-	return fmt.Sprintf("https://www.fitbit.com/oauth2/authorize?response_type=code&client_id=%s&redirect_uri=http://localhost:8090&scope=heartrate&expires_in=604800", oauthClientID)
+	scope := url.PathEscape(strings.Join(requestedScopes, " "))
+	return fmt.Sprintf("https://www.fitbit.com/oauth2/authorize?response_type=code&client_id=%s&redirect_uri=http://localhost:8090&scope=%s&expires_in=604800", oauthClientID, scope)
 }
 
 // Needed since Windows CLI closes immediately.
@@ -216,6 +327,24 @@ func pressEnterToExit() {
 	os.Exit(0)
 }
 
+// newClient returns a FitbitClient authenticated with the credentials in c.
+func newClient(c Config) *FitbitClient {
+	client := NewFitbitClient(c.AppCredentials, c.UserCredentials)
+	if c.APIBaseURL != "" {
+		client.BaseURL = c.APIBaseURL
+	}
+	client.Retry = c.Retry.withDefaults()
+	for _, sc := range c.Sinks {
+		sink, err := newSink(sc)
+		if err != nil {
+			log.Print("Not forwarding to sink: ", err.Error())
+			continue
+		}
+		client.Sinks = append(client.Sinks, sink)
+	}
+	return client
+}
+
 func readConfigFile() (Config, error) {
 	b, err := ioutil.ReadFile("config.json")
 	if err != nil {
@@ -238,7 +367,52 @@ func writeConfigFile(c Config) error {
 	return nil
 }
 
-func validateConfig(c Config) error {
+// validateConfig reports the first problem with c, loading its location on the way.
+func validateConfig(c *Config) error {
+	for _, name := range c.enabledBanners() {
+		if _, ok := bannerGenerators[name]; !ok {
+			return fmt.Errorf("unknown banner %q in banners", name)
+		}
+	}
+	for name, secs := range c.RefreshIntervals {
+		if _, ok := bannerGenerators[name]; !ok {
+			return fmt.Errorf("unknown banner %q in refresh_intervals", name)
+		}
+		if secs < 0 {
+			return fmt.Errorf("invalid refresh_intervals for %s: must not be negative", name)
+		}
+	}
+	for _, panel := range c.Dashboard.Panels {
+		if _, ok := panelGenerators[panel.Type]; !ok {
+			return fmt.Errorf("unknown panel type %q in dashboard", panel.Type)
+		}
+	}
+	if _, ok := detailLevels[c.DetailLevel]; !ok && c.DetailLevel != "" {
+		return fmt.Errorf("invalid detail_level %q: must be 1sec, 1min, 5min or 15min", c.DetailLevel)
+	}
+	switch c.Gaps.Policy {
+	case "", gapForwardFill, gapBreak, gapInterpolate:
+	default:
+		return fmt.Errorf("invalid gaps policy %q: must be forward_fill, break or interpolate", c.Gaps.Policy)
+	}
+	if c.Export.Enabled && c.Export.Token == "" {
+		return fmt.Errorf("export.enabled needs an export.token for requests to send as a bearer token")
+	}
+	if c.Metrics.Enabled && c.Metrics.Token == "" {
+		return fmt.Errorf("metrics.enabled needs a metrics.token for Prometheus to send as a bearer token")
+	}
+	for i, sc := range c.Sinks {
+		if _, err := newSink(sc); err != nil {
+			return fmt.Errorf("invalid sinks[%d]: %w", i, err)
+		}
+	}
+	if c.TimezoneName != "" {
+		loc, err := time.LoadLocation(c.TimezoneName)
+		if err != nil {
+			return fmt.Errorf("invalid timezone_name: %w", err)
+		}
+		c.loc = loc
+	}
 	if err := validateUserCredentials(c.UserCredentials); err != nil {
 		return err
 	}
@@ -273,3 +447,93 @@ func validateUserCredentials(uc UserCredentials) error {
 	}
 	return nil
 }
+
+// requestTimeout returns how long fetching new data from FitBit may take, defaulting to 20 seconds.
+func (c Config) requestTimeout() time.Duration {
+	if c.RequestTimeout <= 0 {
+		return 20 * time.Second
+	}
+	return time.Duration(c.RequestTimeout) * time.Second
+}
+
+// location returns the timezone data is fetched and displayed in.
+func (c Config) location() *time.Location {
+	if c.loc != nil {
+		return c.loc
+	}
+	if c.TimezoneName != "" { // not validated, e.g. built in a test
+		if loc, err := time.LoadLocation(c.TimezoneName); err == nil {
+			return loc
+		}
+	}
+	return time.FixedZone(c.TimezoneAbbreviation, c.Timezone*3600)
+}
+
+// localTimezoneName returns the IANA name of this computer's timezone, or "" if it cannot be determined.
+func localTimezoneName() string {
+	if tz := os.Getenv("TZ"); tz != "" {
+		return strings.TrimPrefix(tz, ":")
+	}
+	target, err := os.Readlink("/etc/localtime") // e.g. /usr/share/zoneinfo/America/Chicago
+	if err != nil {
+		return ""
+	}
+	i := strings.Index(target, "zoneinfo/")
+	if i < 0 {
+		return ""
+	}
+	name := target[i+len("zoneinfo/"):]
+	if _, err := time.LoadLocation(name); err != nil {
+		return ""
+	}
+	return name
+}
+
+// detailLevels maps each detail level FitBit serves intraday heart-rate data at to the interval between its datapoints.
+var detailLevels = map[string]time.Duration{
+	"1sec":  time.Second,
+	"1min":  time.Minute,
+	"5min":  5 * time.Minute,
+	"15min": 15 * time.Minute,
+}
+
+// detailLevel returns the detail level to request heart-rate data at and the interval between its datapoints.
+func (c Config) detailLevel() (string, time.Duration) {
+	if interval, ok := detailLevels[c.DetailLevel]; ok {
+		return c.DetailLevel, interval
+	}
+	return "1min", time.Minute
+}
+
+// enabledBanners returns the names of the banners to serve.
+func (c Config) enabledBanners() []string {
+	if len(c.Banners) == 0 {
+		return []string{"stats"}
+	}
+	return c.Banners
+}
+
+// refreshInterval returns how long to wait between regenerating the banner called name,
+// never less than minRefreshInterval.
+func (c Config) refreshInterval(name string) time.Duration {
+	interval := time.Second * time.Duration(c.CacheInvalidationTime)
+	if secs := c.RefreshIntervals[name]; secs > 0 {
+		interval = time.Duration(secs) * time.Second
+	} else if dailyBanners[name] && interval < dailyRefreshInterval {
+		interval = dailyRefreshInterval
+	}
+	if interval < minRefreshInterval {
+		interval = minRefreshInterval
+	}
+	return interval
+}
+
+// bannerEnabled reports whether the banner called name is served.
+func (c Config) bannerEnabled(name string) bool {
+	for _, b := range c.enabledBanners() {
+		if b == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink receives datapoints newly fetched from FitBit, e.g. to forward them to a time-series database.
+// Implementations must be safe for concurrent use.
+type Sink interface {
+	// Write receives datapoints of metric, in order.
+	Write(ctx context.Context, metric Metric, data []Datapoint) error
+}
+
+// SinkConfig configures a sink datapoints are forwarded to.
+type SinkConfig struct {
+	// Type is the kind of sink: influxdb or file.
+	Type string `json:"type"`
+
+	// URL is the InfluxDB write endpoint e.g., http://localhost:8086/api/v2/write?org=ORG&bucket=BUCKET,
+	// or http://localhost:8086/write?db=DB for InfluxDB 1.x.
+	URL string `json:"url,omitempty"`
+
+	// Token authenticates with InfluxDB 2. Optional.
+	Token string `json:"token,omitempty"`
+
+	// Measurement is the InfluxDB measurement datapoints are written to, tagged with their metric. Defaults to "fitbit".
+	Measurement string `json:"measurement,omitempty"`
+
+	// Path is the file a file sink appends datapoints to, as JSON Lines like /export?format=jsonl.
+	Path string `json:"path,omitempty"`
+}
+
+// newSink returns the sink sc configures.
+func newSink(sc SinkConfig) (Sink, error) {
+	switch sc.Type {
+	case "influxdb":
+		if sc.URL == "" {
+			return nil, fmt.Errorf("influxdb sink needs a url")
+		}
+		measurement := sc.Measurement
+		if measurement == "" {
+			measurement = "fitbit"
+		}
+		return &influxSink{url: sc.URL, token: sc.Token, measurement: measurement, client: &http.Client{Timeout: sinkTimeout}}, nil
+	case "file":
+		if sc.Path == "" {
+			return nil, fmt.Errorf("file sink needs a path")
+		}
+		return &fileSink{path: sc.Path}, nil
+	}
+	return nil, fmt.Errorf("unknown sink type %q: must be influxdb or file", sc.Type)
+}
+
+// influxSink writes datapoints to InfluxDB in its line protocol over HTTP.
+type influxSink struct {
+	url         string
+	token       string
+	measurement string
+	client      *http.Client
+}
+
+func (s *influxSink) Write(ctx context.Context, metric Metric, data []Datapoint) error {
+	var b strings.Builder
+	for _, pt := range data {
+		fmt.Fprintf(&b, "%s,metric=%s value=%di %d\n",
+			influxEscaper.Replace(s.measurement), influxEscaper.Replace(string(metric)), pt.Value, pt.DateTime.UnixNano())
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, strings.NewReader(b.String()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error writing to InfluxDB: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("error writing to InfluxDB: %s - %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// influxEscaper escapes measurements and tag values in InfluxDB's line protocol.
+var influxEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+// fileSink appends datapoints to a file as JSON Lines.
+type fileSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (s *fileSink) Write(ctx context.Context, metric Metric, data []Datapoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening sink file: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, pt := range data {
+		if err := enc.Encode(exportRecord{Metric: metric, Time: pt.DateTime.Format(time.RFC3339), Value: pt.Value}); err != nil {
+			f.Close()
+			return fmt.Errorf("error writing to sink file: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing to sink file: %w", err)
+	}
+	return f.Close()
+}
+
+// sinkTimeout bounds each write to a sink, so a sink that hangs holds up refreshing banners only so long.
+const sinkTimeout = 10 * time.Second
+
+// maxUnsentDatapoints is how many datapoints of a metric are kept for a sink that fails to take them,
+// to retry with the next ones. Past it, the oldest are dropped.
+const maxUnsentDatapoints = 100000
+
+// recordedWindow is how far back from the newest datapoint forwarded without a store datapoints fetched again
+// are compared with what was forwarded, so ones whose value changed, e.g. the steps of the current minute, are
+// forwarded again. Older datapoints are assumed forwarded.
+const recordedWindow = 24 * time.Hour
+
+// recordedSeries is what was forwarded of a metric without a store.
+type recordedSeries struct {
+	newest int64         // unix seconds of the newest datapoint forwarded
+	values map[int64]int // the value forwarded at each time within recordedWindow of newest
+}
+
+// record keeps data fetched as metric in the store, if there is one, and forwards the datapoints among it
+// not fetched before, or whose value changed, to each sink. Sinks failing are logged rather than failing the fetch.
+func (c *FitbitClient) record(metric Metric, data []Datapoint) error {
+	fresh := make([]Datapoint, 0)
+	if c.Store != nil {
+		added, err := c.Store.Add(metric, data)
+		if err != nil {
+			return err
+		}
+		fresh = added
+	} else if len(c.Sinks) > 0 {
+		fresh = c.sinceLastRecorded(metric, data)
+	}
+	c.forward(metric, fresh)
+	return nil
+}
+
+// forward writes data to each sink, after any datapoints of metric the sink failed to take before.
+// Each write gets sinkTimeout, whatever the deadline of the fetch. Datapoints a sink fails to take are kept
+// and retried with the next ones, so they aren't lost to a sink being down for a while.
+func (c *FitbitClient) forward(metric Metric, data []Datapoint) {
+	for _, s := range c.Sinks {
+		batch := c.takeUnsent(s, metric, data)
+		if len(batch) == 0 {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), sinkTimeout)
+		err := s.Write(ctx, metric, batch)
+		cancel()
+		if err != nil {
+			log.Printf("Error forwarding %d %s datapoints to a sink, will retry with the next ones: %s", len(batch), metric, err.Error())
+			c.keepUnsent(s, metric, batch)
+		}
+	}
+}
+
+// takeUnsent returns the datapoints of metric s failed to take before followed by data, and forgets the former.
+func (c *FitbitClient) takeUnsent(s Sink, metric Metric, data []Datapoint) []Datapoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	unsent := c.unsent[s][metric]
+	if len(unsent) == 0 {
+		return data
+	}
+	delete(c.unsent[s], metric)
+	return append(unsent, data...)
+}
+
+// keepUnsent keeps batch to retry writing to s, before any datapoints of metric kept since it was taken.
+func (c *FitbitClient) keepUnsent(s Sink, metric Metric, batch []Datapoint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.unsent == nil {
+		c.unsent = make(map[Sink]map[Metric][]Datapoint)
+	}
+	if c.unsent[s] == nil {
+		c.unsent[s] = make(map[Metric][]Datapoint)
+	}
+	unsent := append(batch, c.unsent[s][metric]...)
+	if n := len(unsent) - maxUnsentDatapoints; n > 0 {
+		log.Printf("Dropping the %d oldest %s datapoints a sink failed to take", n, metric)
+		unsent = unsent[n:]
+	}
+	c.unsent[s][metric] = unsent
+}
+
+// sinceLastRecorded returns the measured datapoints in data not forwarded before as metric, or forwarded with
+// a different value. It stands in for the store when there isn't one, so datapoints fetched again aren't
+// forwarded twice.
+func (c *FitbitClient) sinceLastRecorded(metric Metric, data []Datapoint) []Datapoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.recorded == nil {
+		c.recorded = make(map[Metric]*recordedSeries)
+	}
+	rs := c.recorded[metric]
+	if rs == nil {
+		rs = &recordedSeries{values: make(map[int64]int)}
+		c.recorded[metric] = rs
+	}
+
+	window := int64(recordedWindow / time.Second)
+	fresh := make([]Datapoint, 0)
+	for _, pt := range sortDedup(data) {
+		t := pt.DateTime.Unix()
+		if pt.Filled || (len(rs.values) > 0 && t <= rs.newest-window) {
+			continue
+		}
+		if v, ok := rs.values[t]; ok && v == pt.Value {
+			continue
+		}
+		rs.values[t] = pt.Value
+		if t > rs.newest {
+			rs.newest = t
+		}
+		fresh = append(fresh, pt)
+	}
+	for t := range rs.values {
+		if t <= rs.newest-window {
+			delete(rs.values, t)
+		}
+	}
+	return fresh
+}
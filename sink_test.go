@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memorySink keeps the datapoints written to it as METRIC@UNIX=VALUE.
+type memorySink struct {
+	mu      sync.Mutex
+	written []string
+}
+
+func (s *memorySink) Write(ctx context.Context, metric Metric, data []Datapoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, pt := range data {
+		s.written = append(s.written, fmt.Sprintf("%s@%d=%d", metric, pt.DateTime.Unix(), pt.Value))
+	}
+	return nil
+}
+
+func (s *memorySink) take() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := strings.Join(s.written, " ")
+	s.written = nil
+	return w
+}
+
+func TestInfluxSink(t *testing.T) {
+	var body, auth string
+	influx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body, auth = string(b), r.Header.Get("Authorization")
+		if r.URL.Query().Get("bucket") != "fitbit" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"code":"not found","message":"bucket not found"}`)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer influx.Close()
+
+	sink, err := newSink(SinkConfig{Type: "influxdb", URL: influx.URL + "/api/v2/write?org=me&bucket=fitbit", Token: "TOKEN", Measurement: "my watch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []Datapoint{{DateTime: time.Unix(1615039200, 0), Value: 60}, {DateTime: time.Unix(1615039260, 0), Value: 72}}
+	if err := sink.Write(context.Background(), MetricHeartRate, data); err != nil {
+		t.Fatal(err)
+	}
+	want := "my\\ watch,metric=heart_rate value=60i 1615039200000000000\nmy\\ watch,metric=heart_rate value=72i 1615039260000000000\n"
+	if body != want {
+		t.Errorf("wrote\n%s\nwant\n%s", body, want)
+	}
+	if auth != "Token TOKEN" {
+		t.Errorf("got Authorization %q, want the token", auth)
+	}
+
+	sink, _ = newSink(SinkConfig{Type: "influxdb", URL: influx.URL + "/api/v2/write?org=me&bucket=missing"})
+	if err := sink.Write(context.Background(), MetricHeartRate, data); err == nil || !strings.Contains(err.Error(), "bucket not found") {
+		t.Errorf("got error %v, want InfluxDB's", err)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fitbit.jsonl")
+	sink, err := newSink(SinkConfig{Type: "file", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []int{60, 72} {
+		if err := sink.Write(context.Background(), MetricHeartRate, []Datapoint{{DateTime: time.Unix(1615039200, 0).UTC(), Value: v}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"metric":"heart_rate","time":"2021-03-06T14:00:00Z","value":60}` + "\n" + `{"metric":"heart_rate","time":"2021-03-06T14:00:00Z","value":72}` + "\n"
+	if string(b) != want {
+		t.Errorf("got file\n%s\nwant\n%s", b, want)
+	}
+}
+
+func Test_newSink_errors(t *testing.T) {
+	for _, sc := range []SinkConfig{{Type: "kafka"}, {Type: "influxdb"}, {Type: "file"}} {
+		if _, err := newSink(sc); err == nil {
+			t.Errorf("expected an error for %+v", sc)
+		}
+	}
+}
+
+func TestFitbitClient_recordForwardsOnlyNewDatapoints(t *testing.T) {
+	at := func(sec int64, value int) Datapoint { return Datapoint{DateTime: time.Unix(sec, 0), Value: value} }
+	filled := at(180, 61)
+	filled.Filled = true
+	first := []Datapoint{at(60, 60), at(120, 61), filled}
+	second := []Datapoint{at(120, 61), filled, at(240, 70)}
+	third := []Datapoint{at(240, 75)} // the minute was still going when it was fetched before
+
+	store, err := openStore(filepath.Join(t.TempDir(), "data.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, tt := range []struct {
+		name  string
+		store *Store
+	}{
+		{"without a store", nil},
+		{"with a store", store},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &memorySink{}
+			c := &FitbitClient{Store: tt.store, Sinks: []Sink{sink}}
+			if err := c.record(MetricHeartRate, first); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := sink.take(), "heart_rate@60=60 heart_rate@120=61"; got != want {
+				t.Errorf("forwarded %s, want %s", got, want)
+			}
+			if err := c.record(MetricHeartRate, second); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := sink.take(), "heart_rate@240=70"; got != want {
+				t.Errorf("forwarded %s after fetching again, want only %s", got, want)
+			}
+			if err := c.record(MetricHeartRate, third); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := sink.take(), "heart_rate@240=75"; got != want {
+				t.Errorf("forwarded %s after the value changed, want %s", got, want)
+			}
+		})
+	}
+}
+
+// flakySink fails while down, and otherwise passes datapoints on to a memorySink.
+type flakySink struct {
+	memorySink
+	down bool
+}
+
+func (s *flakySink) Write(ctx context.Context, metric Metric, data []Datapoint) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return fmt.Errorf("no deadline for the write")
+	}
+	if s.down {
+		return fmt.Errorf("sink is down")
+	}
+	return s.memorySink.Write(ctx, metric, data)
+}
+
+func TestFitbitClient_recordRetriesFailedWrites(t *testing.T) {
+	at := func(sec int64, value int) Datapoint { return Datapoint{DateTime: time.Unix(sec, 0), Value: value} }
+	store, err := openStore(filepath.Join(t.TempDir(), "data.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	sink := &flakySink{down: true}
+	c := &FitbitClient{Store: store, Sinks: []Sink{sink}}
+
+	if err := c.record(MetricHeartRate, []Datapoint{at(60, 60), at(120, 61)}); err != nil {
+		t.Fatal(err)
+	}
+	sink.down = false
+	if err := c.record(MetricHeartRate, []Datapoint{at(120, 61), at(180, 62)}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sink.take(), "heart_rate@60=60 heart_rate@120=61 heart_rate@180=62"; got != want {
+		t.Errorf("forwarded %s once the sink was back, want %s", got, want)
+	}
+	if err := c.record(MetricHeartRate, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.take(); got != "" {
+		t.Errorf("forwarded %s again after they were taken", got)
+	}
+}
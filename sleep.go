@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"log"
+	"time"
+)
+
+// SleepConfig configures the /sleep.svg banner.
+type SleepConfig struct {
+	// Title is the title at the top of the banner. Defaults to "My Sleep Last Night".
+	Title string `json:"title"`
+}
+
+func (sc SleepConfig) withDefaults() SleepConfig {
+	if sc.Title == "" {
+		sc.Title = "My Sleep Last Night"
+	}
+	return sc
+}
+
+// Sleep stages as plotted on the Y axis of the hypnogram, from deepest to awake.
+const (
+	stageDeep = iota
+	stageLight
+	stageREM
+	stageWake
+)
+
+var sleepStageTicks = plot.ConstantTicks{
+	{Value: stageDeep, Label: "Deep"},
+	{Value: stageLight, Label: "Light"},
+	{Value: stageREM, Label: "REM"},
+	{Value: stageWake, Label: "Wake"},
+}
+
+// sleepStage returns the hypnogram stage for a FitBit sleep level.
+// Classic logs, recorded when stages could not be detected, only tell asleep, restless and awake apart.
+func sleepStage(level string) (int, bool) {
+	switch level {
+	case "deep":
+		return stageDeep, true
+	case "light", "asleep", "restless":
+		return stageLight, true
+	case "rem":
+		return stageREM, true
+	case "wake", "awake":
+		return stageWake, true
+	}
+	return 0, false
+}
+
+// sleepTimeLayout is how FitBit formats times in sleep logs.
+const sleepTimeLayout = "2006-01-02T15:04:05.000"
+
+// lastNightsSleep returns the main sleep logged ending today.
+func (c *FitbitClient) lastNightsSleep(ctx context.Context, config Config) (SleepLog, error) {
+	if err := c.requireScopes("sleep"); err != nil {
+		return SleepLog{}, err
+	}
+	date, _ := dateHourMin(c.clock().In(config.location()))
+	path := fmt.Sprintf(`/1.2/user/%s/sleep/date/%s.json`, c.credentials().UserID, date)
+
+	logs := SleepLogs{}
+	if err := c.get(ctx, path, &logs); err != nil {
+		return SleepLog{}, fmt.Errorf("error grabbing sleep data: %w", err)
+	}
+	return mainSleep(logs.Sleep, date)
+}
+
+// mainSleep returns the sleep FitBit marked as the main one, or the longest if none is.
+func mainSleep(logs []SleepLog, date string) (SleepLog, error) {
+	if len(logs) == 0 {
+		return SleepLog{}, fmt.Errorf("no sleep logged for %s", date)
+	}
+	longest := logs[0]
+	for _, l := range logs {
+		if l.IsMainSleep {
+			return l, nil
+		}
+		if l.TimeInBed > longest.TimeInBed {
+			longest = l
+		}
+	}
+	return longest, nil
+}
+
+// hypnogram returns the sleep stage at each minute of sl, with X as unix time.
+// Short wakes are laid over the stages they interrupt.
+func hypnogram(sl SleepLog, loc *time.Location) (plotter.XYs, error) {
+	start, err := time.ParseInLocation(sleepTimeLayout, sl.StartTime, loc)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing sleep start time: %w", err)
+	}
+	end, err := time.ParseInLocation(sleepTimeLayout, sl.EndTime, loc)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing sleep end time: %w", err)
+	}
+	start = start.Truncate(time.Minute)
+	minutes := int(end.Sub(start) / time.Minute)
+	if minutes <= 0 {
+		return nil, fmt.Errorf("sleep ends before it starts")
+	}
+
+	stages := make([]int, minutes)
+	for i := range stages {
+		stages[i] = -1
+	}
+	for _, levels := range [][]SleepLevel{sl.Levels.Data, sl.Levels.ShortData} {
+		for _, lvl := range levels {
+			stage, ok := sleepStage(lvl.Level)
+			if !ok {
+				continue
+			}
+			t, err := time.ParseInLocation(sleepTimeLayout, lvl.DateTime, loc)
+			if err != nil {
+				continue
+			}
+			from := int(t.Truncate(time.Minute).Sub(start) / time.Minute)
+			for m := from; m < from+lvl.Seconds/60 && m < minutes; m++ {
+				if m >= 0 {
+					stages[m] = stage
+				}
+			}
+		}
+	}
+
+	xys := make(plotter.XYs, 0, minutes)
+	for m, stage := range stages {
+		if stage < 0 {
+			continue
+		}
+		xys = append(xys, plotter.XY{
+			X: float64(start.Add(time.Duration(m) * time.Minute).Unix()),
+			Y: float64(stage),
+		})
+	}
+	return xys, nil
+}
+
+func updateSleepSVG(ctx context.Context, client *FitbitClient, c Config) (string, error) {
+	sl, err := client.lastNightsSleep(ctx, c)
+	if err != nil {
+		return "", bannerError("grabbing sleep log", err)
+	}
+	banner, err := genSleepBanner(sl, c)
+	if err != nil {
+		return "", bannerError("generating sleep banner", err)
+	}
+	return banner, nil
+}
+
+// genSleepBanner plots a hypnogram of sl, with total sleep, efficiency and bed and wake times on the left.
+func genSleepBanner(sl SleepLog, config Config) (string, error) {
+	loc := config.location()
+	timeSeries, err := hypnogram(sl, loc)
+	if err != nil {
+		return "", err
+	}
+	if len(timeSeries) <= 0 {
+		return defaultBanner(config), fmt.Errorf("data set empty")
+	}
+
+	thirdWidth := config.BannerWidth / 3 // summary takes up 1/3rd, plot 2/3rd
+	plotWidth := thirdWidth * 2
+
+	p := newPlot(config)
+	p.Add(stageBars(timeSeries, config.Theme)...)
+	line, err := plotter.NewLine(timeSeries)
+	if err != nil {
+		return "", err
+	}
+	line.Color = RGBAFromString(config.Theme.PlotLine)
+	p.Add(line)
+	p.Y.Tick.Marker = sleepStageTicks
+	p.Y.Min, p.Y.Max = stageDeep-0.5, stageWake+0.5
+
+	bed, _ := time.ParseInLocation(sleepTimeLayout, sl.StartTime, loc)
+	wake, _ := time.ParseInLocation(sleepTimeLayout, sl.EndTime, loc)
+
+	tData := newTemplate(config, config.Sleep.withDefaults().Title)
+	tData.Plot = renderPlot(p, plotWidth, config)
+	tData.Icon = genSleepSummary(sl.Efficiency, bed, wake, thirdWidth, config.BannerHeight, config.Theme.CurrentBPM)
+	tData.Value = fmt.Sprintf("%dh %02dm", sl.MinutesAsleep/60, sl.MinutesAsleep%60)
+	tData.ValueSize = 26
+	tData.ValueColor = config.Theme.CurrentBPM
+	tData.Caption = "Total Sleep"
+	return execTemplate(tData)
+}
+
+// stageBars returns a bar behind each run of minutes spent in the same stage, colored by stage.
+func stageBars(timeSeries plotter.XYs, theme Theme) []plot.Plotter {
+	bars := make([]plot.Plotter, 0)
+	for i := 0; i < len(timeSeries); {
+		j := i + 1
+		for j < len(timeSeries) && timeSeries[j].Y == timeSeries[i].Y && timeSeries[j].X-timeSeries[j-1].X <= 60 {
+			j++
+		}
+		x0, x1 := timeSeries[i].X, timeSeries[j-1].X+60
+		y := timeSeries[i].Y
+		bar, err := plotter.NewPolygon(plotter.XYs{{X: x0, Y: y - 0.35}, {X: x1, Y: y - 0.35}, {X: x1, Y: y + 0.35}, {X: x0, Y: y + 0.35}})
+		if err != nil {
+			log.Println("Error generating sleep stage bar:", err)
+			i = j
+			continue
+		}
+		bar.Color = RGBAFromString(theme.sleepStageColor(int(y)))
+		bar.LineStyle.Width = 0
+		bars = append(bars, bar)
+		i = j
+	}
+	return bars
+}
+
+// genSleepSummary returns sleep efficiency above and bed and wake times below the center of a width by height box.
+func genSleepSummary(efficiency int, bed, wake time.Time, width, height int, textColor string) string {
+	return fmt.Sprintf(`
+	<g transform="translate(%d %d)" style="font: 600 10pt 'Arial', Sans-Serif; fill: %s;" text-anchor="middle">
+		<text y="-30">%d%% efficiency</text>
+		<text y="36">%s – %s</text>
+	</g>
+	`, width/2, height/2, textColor, efficiency, bed.Format("15:04"), wake.Format("15:04"))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSleepLog() SleepLog {
+	sl := SleepLog{
+		StartTime:     "2021-03-01T23:00:30.000",
+		EndTime:       "2021-03-01T23:10:30.000",
+		Efficiency:    90,
+		IsMainSleep:   true,
+		MinutesAsleep: 8,
+		TimeInBed:     10,
+		Type:          "stages",
+	}
+	sl.Levels.Data = []SleepLevel{
+		{DateTime: "2021-03-01T23:00:30.000", Level: "wake", Seconds: 120},
+		{DateTime: "2021-03-01T23:02:30.000", Level: "light", Seconds: 240},
+		{DateTime: "2021-03-01T23:06:30.000", Level: "deep", Seconds: 240},
+	}
+	sl.Levels.ShortData = []SleepLevel{
+		{DateTime: "2021-03-01T23:04:30.000", Level: "wake", Seconds: 60},
+	}
+	return sl
+}
+
+func Test_hypnogram(t *testing.T) {
+	xys, err := hypnogram(testSleepLog(), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{stageWake, stageWake, stageLight, stageLight, stageWake, stageLight, stageDeep, stageDeep, stageDeep, stageDeep}
+	if len(xys) != len(want) {
+		t.Fatalf("got %d points, want %d", len(xys), len(want))
+	}
+	start := time.Date(2021, 3, 1, 23, 0, 0, 0, time.UTC).Unix()
+	for i, pt := range xys {
+		if int(pt.Y) != want[i] {
+			t.Errorf("minute %d: got stage %v, want %v", i, pt.Y, want[i])
+		}
+		if int64(pt.X) != start+int64(i*60) {
+			t.Errorf("minute %d: got time %v, want %v", i, pt.X, start+int64(i*60))
+		}
+	}
+}
+
+func Test_mainSleep(t *testing.T) {
+	nap := SleepLog{StartTime: "nap", TimeInBed: 30}
+	night := SleepLog{StartTime: "night", TimeInBed: 420}
+	tests := []struct {
+		name    string
+		logs    []SleepLog
+		want    string
+		wantErr bool
+	}{
+		{"none", nil, "", true},
+		{"longest", []SleepLog{nap, night}, "night", false},
+		{"marked main", []SleepLog{night, {StartTime: "main", TimeInBed: 60, IsMainSleep: true}}, "main", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mainSleep(tt.logs, "2021-03-02")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mainSleep() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.StartTime != tt.want {
+				t.Errorf("mainSleep() = %q, want %q", got.StartTime, tt.want)
+			}
+		})
+	}
+}
+
+func Test_genSleepBanner(t *testing.T) {
+	config := testBannerConfig()
+	config.TimezoneName = "UTC"
+	banner, err := genSleepBanner(testSleepLog(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"0h 08m", "90% efficiency", "23:00 – 23:10", "My Sleep Last Night", "Deep"} {
+		if !strings.Contains(banner, want) {
+			t.Errorf("banner is missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"image/color"
+	"time"
+)
+
+// SpO2Config configures the /spo2.svg banner.
+type SpO2Config struct {
+	// Title is the title at the top of the banner. Defaults to "My Nightly SpO2 & Breathing Rate (Past 2 Weeks)".
+	Title string `json:"title"`
+}
+
+func (sc SpO2Config) withDefaults() SpO2Config {
+	if sc.Title == "" {
+		sc.Title = "My Nightly SpO2 & Breathing Rate (Past 2 Weeks)"
+	}
+	return sc
+}
+
+// spo2Days is how many nights the SpO2 banner covers.
+const spo2Days = 14
+
+// SpO2Day is the blood oxygen saturation FitBit measured during the night ending on DateTime.
+type SpO2Day struct {
+	DateTime string `json:"dateTime"`
+	Value    struct {
+		Avg float64 `json:"avg"`
+		Min float64 `json:"min"`
+		Max float64 `json:"max"`
+	} `json:"value"`
+}
+
+// BreathingRates contains the average breathing rate of each night from FitBit's API.
+type BreathingRates struct {
+	BR []struct {
+		DateTime string `json:"dateTime"`
+		Value    struct {
+			BreathingRate float64 `json:"breathingRate"`
+		} `json:"value"`
+	} `json:"br"`
+}
+
+// nightlyVitals holds a point per night for each measurement, with X as unix time.
+type nightlyVitals struct {
+	SpO2Avg, SpO2Min, SpO2Max plotter.XYs
+	BreathingRate             plotter.XYs
+}
+
+// nightlyVitals returns SpO2 and breathing rate for each of the past spo2Days nights. Nights without data are left out.
+func (c *FitbitClient) nightlyVitals(ctx context.Context, config Config) (nightlyVitals, error) {
+	if err := c.requireScopes("oxygen_saturation", "respiratory_rate"); err != nil {
+		return nightlyVitals{}, err
+	}
+	loc := config.location()
+	now := c.clock().In(loc)
+	endDate, _ := dateHourMin(now)
+	startDate, _ := dateHourMin(now.AddDate(0, 0, -(spo2Days - 1)))
+	userID := c.credentials().UserID
+
+	var spo2 []SpO2Day
+	if err := c.get(ctx, fmt.Sprintf(`/1/user/%s/spo2/date/%s/%s.json`, userID, startDate, endDate), &spo2); err != nil {
+		return nightlyVitals{}, fmt.Errorf("error grabbing SpO2 data: %w", err)
+	}
+	br := BreathingRates{}
+	if err := c.get(ctx, fmt.Sprintf(`/1/user/%s/br/date/%s/%s.json`, userID, startDate, endDate), &br); err != nil {
+		return nightlyVitals{}, fmt.Errorf("error grabbing breathing rate data: %w", err)
+	}
+
+	v := nightlyVitals{}
+	for _, day := range spo2 {
+		date, err := time.ParseInLocation("2006-01-02", day.DateTime, loc)
+		if err != nil || day.Value.Avg <= 0 {
+			continue
+		}
+		x := float64(date.Unix())
+		v.SpO2Avg = append(v.SpO2Avg, plotter.XY{X: x, Y: day.Value.Avg})
+		v.SpO2Min = append(v.SpO2Min, plotter.XY{X: x, Y: day.Value.Min})
+		v.SpO2Max = append(v.SpO2Max, plotter.XY{X: x, Y: day.Value.Max})
+	}
+	for _, day := range br.BR {
+		date, err := time.ParseInLocation("2006-01-02", day.DateTime, loc)
+		if err != nil || day.Value.BreathingRate <= 0 {
+			continue
+		}
+		v.BreathingRate = append(v.BreathingRate, plotter.XY{X: float64(date.Unix()), Y: day.Value.BreathingRate})
+	}
+	return v, nil
+}
+
+func updateSpO2SVG(ctx context.Context, client *FitbitClient, c Config) (string, error) {
+	v, err := client.nightlyVitals(ctx, c)
+	if err != nil {
+		return "", bannerError("grabbing SpO2 and breathing rate", err)
+	}
+	banner, err := genSpO2Banner(v, c)
+	if err != nil {
+		return "", bannerError("generating SpO2 banner", err)
+	}
+	return banner, nil
+}
+
+// genSpO2Banner lays out like genBanner, with a drop of blood showing last night's SpO2 in place of the heart.
+// SpO2 and its min/max band are plotted over breathing rate.
+func genSpO2Banner(v nightlyVitals, config Config) (string, error) {
+	if len(v.SpO2Avg) <= 0 {
+		return defaultBanner(config), fmt.Errorf("data set empty")
+	}
+	latest := v.SpO2Avg[len(v.SpO2Avg)-1].Y
+
+	thirdWidth := config.BannerWidth / 3 // drop takes up 1/3rd, plots 2/3rd
+	plotWidth := thirdWidth * 2
+	plotHeight := config.BannerHeight / 2
+
+	band, err := minMaxBand(v.SpO2Min, v.SpO2Max, spo2BandColor(config.Theme))
+	if err != nil {
+		return "", err
+	}
+	spo2Plot, err := nightlyPlot(v.SpO2Avg, "SpO2 %", config, band)
+	if err != nil {
+		return "", err
+	}
+	plots := renderPlotAt(spo2Plot, 0, plotWidth, plotHeight)
+	if len(v.BreathingRate) > 0 {
+		brPlot, err := nightlyPlot(v.BreathingRate, "Breaths/min", config)
+		if err != nil {
+			return "", err
+		}
+		plots += renderPlotAt(brPlot, plotHeight, plotWidth, plotHeight)
+	}
+
+	tData := newTemplate(config, config.SpO2.withDefaults().Title)
+	tData.Plot = plots
+	tData.Icon = genDrop(thirdWidth, config.Theme.Heart)
+	tData.Value = fmt.Sprintf("%.0f%%", latest)
+	tData.ValueSize = 28
+	tData.ValueColor = config.Theme.HeartNumber
+	tData.Caption = "Nightly SpO2"
+	return execTemplate(tData)
+}
+
+// nightlyPlot plots a value per night as a line over the given underlays, labeling the Y axis with label.
+func nightlyPlot(timeSeries plotter.XYs, label string, config Config, underlays ...plot.Plotter) (*plot.Plot, error) {
+	loc := config.location()
+	p := newPlot(config)
+	p.X.Tick.Marker = plot.TimeTicks{
+		Ticker: dayTicker(loc),
+		Format: "Jan 2",
+		Time: func(t float64) time.Time {
+			return time.Unix(int64(t), 0).In(loc)
+		},
+	}
+	p.Y.Label.Text = label
+	p.Add(underlays...)
+
+	line, err := plotter.NewLine(timeSeries)
+	if err != nil {
+		return nil, err
+	}
+	line.Color = RGBAFromString(config.Theme.PlotLine)
+	p.Add(line)
+	return p, nil
+}
+
+// minMaxBand returns a band filled between the min and max of each night.
+func minMaxBand(min, max plotter.XYs, fill color.Color) (plot.Plotter, error) {
+	outline := make(plotter.XYs, 0, len(min)+len(max))
+	outline = append(outline, min...)
+	for i := len(max) - 1; i >= 0; i-- {
+		outline = append(outline, max[i])
+	}
+	band, err := plotter.NewPolygon(outline)
+	if err != nil {
+		return nil, err
+	}
+	band.Color = fill
+	band.LineStyle.Width = 0
+	return band, nil
+}
+
+// spo2BandColor returns the theme's SpO2 band color, or a faded PlotLine if it has none.
+func spo2BandColor(theme Theme) color.Color {
+	if theme.SpO2Band != "" {
+		return RGBAFromString(theme.SpO2Band)
+	}
+	c := RGBAFromString(theme.PlotLine)
+	c.A = 60
+	return c
+}
+
+func genDrop(width int, dropColor string) string {
+	viewBox := width + width/3
+	gOffset := viewBox / 2
+	drop := fmt.Sprintf(`
+	<svg width="%d" height="%d" viewBox="0 0 %d %d">
+		<g transform="translate(%d %d)">
+			<path transform="translate(-50 -50) scale(1.1)" fill="%s" d="M45,2 C45,2 8,44 8,63 A37,37 0 0 0 82,63 C82,44 45,2 45,2 Z"></path>
+		</g>
+	</svg>
+	`, width, width, viewBox, viewBox, gOffset, gOffset, dropColor)
+
+	drop = fmt.Sprintf(`<g transform="translate(%d %d)"> %s </g>`, 0, -22, drop)
+	return drop
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gonum.org/v1/plot/plotter"
+)
+
+func TestFitbitClient_nightlyVitals(t *testing.T) {
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/spo2/"):
+			fmt.Fprint(w, `[{"dateTime":"2021-10-04","value":{"avg":97.5,"min":94.0,"max":100.0}},{"dateTime":"2021-10-05","value":{}}]`)
+		case strings.Contains(r.URL.Path, "/br/"):
+			fmt.Fprint(w, `{"br":[{"dateTime":"2021-10-04","value":{"breathingRate":15.2}}]}`)
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	c.UserCredentials.Scope = "heartrate oxygen_saturation respiratory_rate"
+
+	v, err := c.nightlyVitals(context.Background(), Config{TimezoneName: "UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.SpO2Avg) != 1 || v.SpO2Avg[0].Y != 97.5 || v.SpO2Min[0].Y != 94 || v.SpO2Max[0].Y != 100 {
+		t.Errorf("got SpO2 %v %v %v, want one night of 97.5 between 94 and 100", v.SpO2Avg, v.SpO2Min, v.SpO2Max)
+	}
+	if len(v.BreathingRate) != 1 || v.BreathingRate[0].Y != 15.2 {
+		t.Errorf("got breathing rate %v, want one night of 15.2", v.BreathingRate)
+	}
+
+	c.UserCredentials.Scope = "heartrate oxygen_saturation"
+	var scopeErr *InsufficientScopeError
+	if _, err := c.nightlyVitals(context.Background(), Config{}); !errors.As(err, &scopeErr) {
+		t.Errorf("got %v without the respiratory_rate scope, want an *InsufficientScopeError", err)
+	}
+}
+
+func Test_genSpO2Banner(t *testing.T) {
+	v := nightlyVitals{}
+	for i, avg := range []float64{96, 97.4} {
+		x := float64(1633305600 + i*86400)
+		v.SpO2Avg = append(v.SpO2Avg, plotter.XY{X: x, Y: avg})
+		v.SpO2Min = append(v.SpO2Min, plotter.XY{X: x, Y: avg - 3})
+		v.SpO2Max = append(v.SpO2Max, plotter.XY{X: x, Y: 100})
+		v.BreathingRate = append(v.BreathingRate, plotter.XY{X: x, Y: 15})
+	}
+	banner, err := genSpO2Banner(v, testBannerConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{">97%<", "Nightly SpO2", "Breaths/min"} {
+		if !strings.Contains(banner, want) {
+			t.Errorf("banner is missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"math"
+	"strconv"
+	"time"
+)
+
+// StepsConfig configures the /steps.svg banner.
+type StepsConfig struct {
+	// Title is the title at the top of the banner. Defaults to "My Steps Today".
+	Title string `json:"title"`
+
+	// Goal is the number of steps to take each day. Defaults to 10000.
+	Goal int `json:"goal"`
+}
+
+func (sc StepsConfig) withDefaults() StepsConfig {
+	if sc.Title == "" {
+		sc.Title = "My Steps Today"
+	}
+	if sc.Goal <= 0 {
+		sc.Goal = 10000
+	}
+	return sc
+}
+
+// StepsTimeSeries contains step count data from FitBit's API.
+type StepsTimeSeries struct {
+	// ActivitiesStepsIntraday has minute-by-minute coverage of the steps a user took.
+	ActivitiesStepsIntraday struct {
+		Dataset         []Datapoint `json:"dataset"`
+		DatasetInterval int         `json:"datasetInterval"`
+		DatasetType     string      `json:"datasetType"`
+	} `json:"activities-steps-intraday"`
+}
+
+// stepsTimeSeries returns the steps taken so far today, summed minute by minute, in a plottable format.
+func (c *FitbitClient) stepsTimeSeries(ctx context.Context, config Config) ([]BannerXY, error) {
+	if err := c.requireScopes("activity"); err != nil {
+		return nil, err
+	}
+	now := c.clock().In(config.location())
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	date, endHr := dateHourMin(now)
+	u := `/1/user/%s/activities/steps/date/%s/1d/1min/time/00:00/%s.json`
+	path := fmt.Sprintf(u, c.credentials().UserID, date, endHr)
+
+	ts := StepsTimeSeries{}
+	if err := c.get(ctx, path, &ts); err != nil {
+		return nil, fmt.Errorf("error grabbing steps data: %w", err)
+	}
+
+	dataset := datePoints(ts.ActivitiesStepsIntraday.Dataset, midnight, time.Minute)
+	if err := c.record(MetricSteps, dataset); err != nil {
+		return nil, err
+	}
+	xy := make([]BannerXY, 0, len(dataset))
+	total := 0
+	for _, pt := range dataset {
+		total += pt.Value
+		xy = append(xy, BannerXY{
+			X: pt.DateTime,
+			Y: total,
+		})
+	}
+	c.Telemetry.observeStepsToday(total, now)
+	return xy, nil
+}
+
+func updateStepsSVG(ctx context.Context, client *FitbitClient, c Config) (string, error) {
+	xy, err := client.stepsTimeSeries(ctx, c)
+	if err != nil {
+		return "", bannerError("grabbing steps time series", err)
+	}
+	banner, err := genStepsBanner(xy, c)
+	if err != nil {
+		return "", bannerError("generating steps banner", err)
+	}
+	return banner, nil
+}
+
+// genStepsBanner plots cumulative steps against the goal, with a ring on the left showing progress toward it.
+func genStepsBanner(xy []BannerXY, config Config) (string, error) {
+	timeSeries := plotXYs(xy)
+	if len(timeSeries) <= 0 {
+		return defaultBanner(config), fmt.Errorf("data set empty")
+	}
+	steps := int(timeSeries[len(timeSeries)-1].Y)
+	stepsConfig := config.Steps.withDefaults()
+
+	thirdWidth := config.BannerWidth / 3 // ring takes up 1/3rd, plot 2/3rd
+	plotWidth := thirdWidth * 2
+
+	goal, err := goalLine(timeSeries, float64(stepsConfig.Goal), config.Theme.Axes)
+	if err != nil {
+		return "", err
+	}
+
+	tData := newTemplate(config, stepsConfig.Title)
+	tData.Plot = genPlot(timeSeries, plotWidth, config, goal)
+	tData.Icon = genRing(float64(steps)/float64(stepsConfig.Goal), thirdWidth, config.BannerHeight, config.Theme.Heart, config.Theme.Axes)
+	tData.Value = thousands(steps)
+	tData.ValueSize = 20
+	tData.ValueColor = config.Theme.CurrentBPM
+	tData.Caption = "Steps Today"
+	return execTemplate(tData)
+}
+
+// goalLine returns a dashed horizontal line at goal spanning the time series.
+func goalLine(timeSeries plotter.XYs, goal float64, lineColor string) (plot.Plotter, error) {
+	xmin, xmax, _, _ := plotter.XYRange(timeSeries)
+	line, err := plotter.NewLine(plotter.XYs{{X: xmin, Y: goal}, {X: xmax, Y: goal}})
+	if err != nil {
+		return nil, err
+	}
+	line.Color = RGBAFromString(lineColor)
+	line.Dashes = []vg.Length{4, 4}
+	return line, nil
+}
+
+// genRing returns a ring centered in a width by height box, filled clockwise from the top by progress (0 to 1).
+func genRing(progress float64, width, height int, ringColor, trackColor string) string {
+	progress = math.Max(0, math.Min(progress, 1))
+	r := float64(height) * 0.38
+	circumference := 2 * math.Pi * r
+	return fmt.Sprintf(`
+	<g transform="translate(%d %d)">
+		<circle r="%.1f" fill="none" stroke="%s" stroke-width="6" />
+		<circle r="%.1f" fill="none" stroke="%s" stroke-width="8" stroke-linecap="round" stroke-dasharray="%.1f %.1f" transform="rotate(-90)" />
+	</g>
+	`, width/2, height/2, r, trackColor, r, ringColor, circumference*progress, circumference)
+}
+
+// thousands formats n with commas between groups of three digits e.g., 12,345.
+func thousands(n int) string {
+	s := strconv.Itoa(n)
+	if n < 0 {
+		return "-" + thousands(-n)
+	}
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_thousands(t *testing.T) {
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1000, "1,000"},
+		{12345, "12,345"},
+		{1234567, "1,234,567"},
+		{-4500, "-4,500"},
+	}
+	for _, tt := range tests {
+		if got := thousands(tt.n); got != tt.want {
+			t.Errorf("thousands(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func Test_missingScopes(t *testing.T) {
+	tests := []struct {
+		granted string
+		scopes  []string
+		want    string
+	}{
+		{"heartrate activity", []string{"heartrate", "activity"}, ""},
+		{"heartrate", []string{"heartrate", "activity"}, "activity"},
+		{"", []string{"heartrate", "activity"}, "heartrate activity"},
+		{"activity heartrate sleep", []string{"heartrate"}, ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(missingScopes(tt.granted, tt.scopes), " "); got != tt.want {
+			t.Errorf("missingScopes(%q, %v) = %q, want %q", tt.granted, tt.scopes, got, tt.want)
+		}
+	}
+}
+
+func TestFitbitClient_stepsTimeSeries(t *testing.T) {
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/activities/steps/") {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		fmt.Fprint(w, `{"activities-steps-intraday":{"dataset":[{"time":"00:00:00","value":10},{"time":"00:01:00","value":0},{"time":"00:02:00","value":25}]}}`)
+	}))
+	c.UserCredentials.Scope = "heartrate activity"
+
+	xy, err := c.stepsTimeSeries(context.Background(), Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]int, 0, len(xy))
+	for _, pt := range xy {
+		got = append(got, pt.Y)
+	}
+	if fmt.Sprint(got) != "[10 10 35]" {
+		t.Errorf("got cumulative steps %v, want [10 10 35]", got)
+	}
+}
+
+func TestFitbitClient_stepsTimeSeries_missingScope(t *testing.T) {
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to %s without the activity scope", r.URL.Path)
+	}))
+	c.UserCredentials.Scope = "heartrate"
+
+	_, err := c.stepsTimeSeries(context.Background(), Config{})
+	var scopeErr *InsufficientScopeError
+	if !errors.As(err, &scopeErr) {
+		t.Errorf("got %v, want an *InsufficientScopeError", err)
+	}
+}
+
+func TestServer_servesEnabledBanners(t *testing.T) {
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"activities-steps-intraday":{"dataset":[{"time":"00:00:00","value":1200}]}}`)
+	}))
+	defer fake.Close()
+	config := testBannerConfig()
+	config.APIBaseURL = fake.URL
+	config.Banners = []string{"steps"}
+	config.UserCredentials.Scope = "heartrate activity"
+	s := newServer(config)
+	s.saveConfig = func(Config) error { return nil }
+
+	s.refresh(context.Background(), "/steps.svg")
+	if got := s.current("/steps.svg"); !strings.Contains(got, "1,200") || !strings.Contains(got, "My Steps Today") {
+		t.Error("expected a generated steps banner after a successful refresh")
+	}
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	for path, want := range map[string]int{"/steps.svg": http.StatusOK, "/stats.svg": http.StatusNotFound} {
+		resp, err := srv.Client().Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s = %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Metric is the kind of measurement a stored datapoint holds.
+type Metric string
+
+const (
+	// MetricHeartRate is heart rate in BPM, at whatever detail level it was fetched at.
+	MetricHeartRate Metric = "heart_rate"
+	// MetricRestingHeartRate is the resting heart rate of each day in BPM, stored at the day's local midnight.
+	MetricRestingHeartRate Metric = "resting_heart_rate"
+	// MetricSteps is the steps taken each minute.
+	MetricSteps Metric = "steps"
+	// MetricBackfilled marks each day -backfill finished fetching, at the day's local midnight.
+	MetricBackfilled Metric = "backfilled"
+)
+
+// Store keeps datapoints fetched from FitBit in a single file on disk, so they outlive a refresh and
+// only new data needs to be fetched. Each metric holds at most one datapoint per second: adding a datapoint
+// at a time already stored replaces it. It is safe for concurrent use.
+//
+// The file is append-only, one JSON record per line. Records superseded by a later one at the same time,
+// or left half-written by a crash, are dropped when the store is opened.
+//
+// Every datapoint stored is also kept in memory, at 16 bytes each: a year of heart rate at the 1min detail level
+// takes about 8 MB, but at 1sec about 500 MB, so a store at 1sec should not be backfilled far.
+type Store struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	series map[Metric][]storedPoint // each sorted by time, without duplicate times
+}
+
+// storedPoint is a datapoint as kept in memory: unix seconds and a value.
+type storedPoint struct {
+	t int64
+	v int
+}
+
+// storeRecord is a line of the store's file.
+type storeRecord struct {
+	Metric Metric `json:"m"`
+	Time   int64  `json:"t"`
+	Value  int    `json:"v"`
+}
+
+// openStore opens the store at path, creating it if it doesn't exist.
+func openStore(path string) (*Store, error) {
+	s := &Store{path: path, series: make(map[Metric][]storedPoint)}
+	lines, dropped, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	stored := 0
+	for m, pts := range s.series {
+		s.series[m] = sortDedupStored(pts)
+		stored += len(s.series[m])
+	}
+	if dropped > 0 {
+		log.Printf("Dropped %d unreadable records from %s", dropped, path)
+	}
+
+	if stored < lines {
+		if err := s.compact(); err != nil {
+			return nil, err
+		}
+	}
+	s.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening store: %w", err)
+	}
+	return s, nil
+}
+
+// load reads the store's file into memory a line at a time, returning how many records it has
+// and how many of them are unreadable. A missing file is an empty store.
+func (s *Store) load() (lines, dropped int, err error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("error reading store: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		lines++
+		rec := storeRecord{}
+		if err := json.Unmarshal(line, &rec); err != nil || rec.Metric == "" {
+			dropped++
+			continue
+		}
+		s.series[rec.Metric] = append(s.series[rec.Metric], storedPoint{t: rec.Time, v: rec.Value})
+	}
+	if err := sc.Err(); err != nil {
+		return 0, 0, fmt.Errorf("error reading store: %w", err)
+	}
+	return lines, dropped, nil
+}
+
+// sortDedupStored sorts pts by time in place, keeping the last of points sharing a time.
+func sortDedupStored(pts []storedPoint) []storedPoint {
+	sort.SliceStable(pts, func(i, j int) bool { return pts[i].t < pts[j].t })
+	out := pts[:0]
+	for _, pt := range pts {
+		if n := len(out); n > 0 && out[n-1].t == pt.t {
+			out[n-1] = pt
+			continue
+		}
+		out = append(out, pt)
+	}
+	return out
+}
+
+// compact rewrites the store's file with only the datapoints in memory, replacing it atomically.
+func (s *Store) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("error compacting store: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	metrics := make([]string, 0, len(s.series))
+	for m := range s.series {
+		metrics = append(metrics, string(m))
+	}
+	sort.Strings(metrics)
+	for _, m := range metrics {
+		for _, pt := range s.series[Metric(m)] {
+			if err := writeRecord(w, storeRecord{Metric: Metric(m), Time: pt.t, Value: pt.v}); err != nil {
+				f.Close()
+				return fmt.Errorf("error compacting store: %w", err)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("error compacting store: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error compacting store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("error compacting store: %w", err)
+	}
+	return nil
+}
+
+func writeRecord(w *bufio.Writer, rec storeRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	return w.WriteByte('\n')
+}
+
+// Add stores data as metric, replacing any datapoints already stored at the same times.
+// Filled in datapoints aren't stored, since they weren't measured.
+// It returns the datapoints that weren't already stored with the same value.
+func (s *Store) Add(metric Metric, data []Datapoint) ([]Datapoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pts := s.series[metric]
+	w := bufio.NewWriter(s.file)
+	added := make([]Datapoint, 0)
+	for _, d := range data {
+		if d.Filled {
+			continue
+		}
+		pt := storedPoint{t: d.DateTime.Unix(), v: d.Value}
+		i := sort.Search(len(pts), func(i int) bool { return pts[i].t >= pt.t })
+		switch {
+		case i < len(pts) && pts[i].t == pt.t:
+			if pts[i].v == pt.v {
+				continue // already stored e.g., the last datapoint when fetching from it onwards
+			}
+			pts[i] = pt
+		case i == len(pts):
+			pts = append(pts, pt)
+		default:
+			pts = append(pts, storedPoint{})
+			copy(pts[i+1:], pts[i:])
+			pts[i] = pt
+		}
+		if err := writeRecord(w, storeRecord{Metric: metric, Time: pt.t, Value: pt.v}); err != nil {
+			return nil, fmt.Errorf("error writing to store: %w", err)
+		}
+		added = append(added, d)
+	}
+	s.series[metric] = pts
+	if len(added) == 0 {
+		return added, nil
+	}
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("error writing to store: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return nil, fmt.Errorf("error writing to store: %w", err)
+	}
+	return added, nil
+}
+
+// Range returns the datapoints stored as metric from start up to and including end, in order.
+// Times are in start's location.
+func (s *Store) Range(metric Metric, start, end time.Time) []Datapoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pts := s.series[metric]
+	from := sort.Search(len(pts), func(i int) bool { return pts[i].t >= start.Unix() })
+	to := sort.Search(len(pts), func(i int) bool { return pts[i].t > end.Unix() })
+	data := make([]Datapoint, 0, to-from)
+	for _, pt := range pts[from:to] {
+		t := time.Unix(pt.t, 0).In(start.Location())
+		data = append(data, Datapoint{Time: t.Format("15:04:05"), DateTime: t, Value: pt.v})
+	}
+	return data
+}
+
+// First returns the time of the earliest datapoint stored as metric at or after from, or false if there are none.
+func (s *Store) First(metric Metric, from time.Time) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pts := s.series[metric]
+	i := sort.Search(len(pts), func(i int) bool { return pts[i].t >= from.Unix() })
+	if i == len(pts) {
+		return time.Time{}, false
+	}
+	return time.Unix(pts[i].t, 0), true
+}
+
+// Last returns the time of the most recent datapoint stored as metric, or false if there are none.
+func (s *Store) Last(metric Metric) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pts := s.series[metric]
+	if len(pts) == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(pts[len(pts)-1].t, 0), true
+}
+
+// Close closes the store's file.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// Metrics returns the metrics with datapoints stored, sorted.
+func (s *Store) Metrics() []Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metrics := make([]Metric, 0, len(s.series))
+	for m, pts := range s.series {
+		if len(pts) > 0 {
+			metrics = append(metrics, m)
+		}
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i] < metrics[j] })
+	return metrics
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.jsonl")
+	store, err := openStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2021, 3, 6, 14, 0, 0, 0, time.UTC)
+	at := func(min, value int) Datapoint {
+		return Datapoint{DateTime: start.Add(time.Duration(min) * time.Minute), Value: value}
+	}
+
+	if _, ok := store.Last(MetricHeartRate); ok {
+		t.Error("expected an empty store to have no last datapoint")
+	}
+	if _, err := store.Add(MetricHeartRate, []Datapoint{at(2, 62), at(0, 60), at(1, 61)}); err != nil {
+		t.Fatal(err)
+	}
+	filled := at(4, 62)
+	filled.Filled = true
+	if _, err := store.Add(MetricHeartRate, []Datapoint{at(2, 72), at(3, 63), filled}); err != nil {
+		t.Fatal(err)
+	}
+	if last, _ := store.Last(MetricHeartRate); !last.Equal(start.Add(3 * time.Minute)) {
+		t.Errorf("got last datapoint at %v, want 14:03", last)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = openStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if got, want := storedValues(store.Range(MetricHeartRate, start.Add(time.Minute), start.Add(time.Hour))), "14:01=61 14:02=72 14:03=63"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got := store.Range("steps", start, start.Add(time.Hour)); len(got) != 0 {
+		t.Errorf("got %d datapoints for a metric never added, want 0", len(got))
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(b), "\n"); lines != 4 {
+		t.Errorf("got %d records after reopening, want the replaced one compacted away leaving 4", lines)
+	}
+}
+
+func TestStore_dropsPartialRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.jsonl")
+	records := `{"m":"heart_rate","t":1615039200,"v":60}` + "\n" + `{"m":"heart_rate","t":1615039260,"v":6`
+	if err := ioutil.WriteFile(path, []byte(records), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := openStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err := store.Add(MetricHeartRate, []Datapoint{{DateTime: time.Unix(1615039320, 0), Value: 62}}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"m":"heart_rate","t":1615039200,"v":60}` + "\n" + `{"m":"heart_rate","t":1615039320,"v":62}` + "\n"
+	if string(b) != want {
+		t.Errorf("got file\n%s\nwant\n%s", b, want)
+	}
+}
+
+func TestFitbitClient_fetchesOnlyMissingTail(t *testing.T) {
+	now := time.Date(2021, 3, 6, 0, 5, 0, 0, time.UTC) // the plot range spans midnight
+	start := now.Add(-4 * time.Hour)
+	last := now.Add(-10 * time.Minute)
+	tail := []Datapoint{{DateTime: last.Add(-time.Minute), Value: 60}, {DateTime: last, Value: 61}}
+	tests := []struct {
+		name   string
+		stored []Datapoint
+		want   string // the window requested
+		values string
+	}{
+		{"store holds the range", append([]Datapoint{{DateTime: start, Value: 58}}, tail...),
+			"/date/2021-03-05/2021-03-06/1min/time/23:55/00:05.json", "20:05=58 23:54=60 23:55=61 23:56=70"},
+		{"store holds only a tail", tail,
+			"/date/2021-03-05/2021-03-06/1min/time/20:05/00:05.json", "23:54=60 23:55=61 23:56=70"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := openStore(filepath.Join(t.TempDir(), "data.jsonl"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			if _, err := store.Add(MetricHeartRate, tt.stored); err != nil {
+				t.Fatal(err)
+			}
+
+			paths := make([]string, 0)
+			c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				paths = append(paths, r.URL.Path)
+				fmt.Fprint(w, `{"activities-heart-intraday":{"dataset":[{"time":"23:55:00","value":61},{"time":"23:56:00","value":70}]}}`)
+			}))
+			c.UserCredentials.APIToken = "token-1"
+			c.Store = store
+			c.now = func() time.Time { return now }
+
+			ts, err := c.rawHeartRateTimeSeries(context.Background(), Config{PlotRange: 4, TimezoneName: "UTC", Gaps: GapConfig{Policy: gapBreak}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(paths) != 1 || !strings.HasSuffix(paths[0], tt.want) {
+				t.Errorf("requested %v, want only %s", paths, tt.want)
+			}
+			if got := storedValues(ts.ActivitiesHeartIntraday.Dataset); got != tt.values {
+				t.Errorf("got %s, want %s", got, tt.values)
+			}
+		})
+	}
+}
+
+// storedValues formats each datapoint as HH:MM=value.
+func storedValues(data []Datapoint) string {
+	s := make([]string, 0, len(data))
+	for _, pt := range data {
+		s = append(s, pt.DateTime.Format("15:04")+"="+fmt.Sprint(pt.Value))
+	}
+	return strings.Join(s, " ")
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"time"
 )
 
 type TZLabel struct {
@@ -30,6 +31,38 @@ func lookupFullTZ(abbrev string, utcOffset int) (TZLabel, error) {
 	return ret, nil
 }
 
+// configTZLabel returns the label of the timezone in config at time t.
+// With a timezone_name, the abbreviation comes from the location, so it follows DST.
+func configTZLabel(config Config, t time.Time) TZLabel {
+	if config.TimezoneName == "" {
+		tzLabel, err := lookupFullTZ(config.TimezoneAbbreviation, config.Timezone)
+		if err != nil {
+			return TZLabel{
+				Abbreviation: config.TimezoneAbbreviation,
+				Full:         "",
+				UTCOffset:    config.Timezone,
+			}
+		}
+		return tzLabel
+	}
+	return locationTZLabel(config.location(), t)
+}
+
+// locationTZLabel returns the label of loc at time t.
+func locationTZLabel(loc *time.Location, t time.Time) TZLabel {
+	abbrev, offset := t.In(loc).Zone()
+	tzLabel, err := lookupFullTZ(abbrev, offset/3600)
+	if err != nil || abs(tzLabel.UTCOffset*3600-offset) >= 3600 {
+		// abbreviations Go has no letters for look like -03 or +0530
+		return TZLabel{
+			Abbreviation: abbrev,
+			Full:         loc.String(),
+			UTCOffset:    offset / 3600,
+		}
+	}
+	return tzLabel
+}
+
 func abs(x int) int {
 	if x < 0 {
 		return x * -1
@@ -3,6 +3,7 @@ package main
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func Test_lookupFullTZ(t *testing.T) {
@@ -37,3 +38,28 @@ func Test_lookupFullTZ(t *testing.T) {
 		})
 	}
 }
+
+func Test_locationTZLabel(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		t        time.Time
+		want     TZLabel
+	}{
+		{"chicago winter", "America/Chicago", time.Date(2021, 1, 15, 12, 0, 0, 0, time.UTC), TZLabel{"CST", "Central Standard Time (North America)", -6}},
+		{"chicago summer", "America/Chicago", time.Date(2021, 7, 15, 12, 0, 0, 0, time.UTC), TZLabel{"CDT", "Central Daylight Time (North America)", -5}},
+		{"india half hour", "Asia/Kolkata", time.Date(2021, 7, 15, 12, 0, 0, 0, time.UTC), TZLabel{"IST", "Indian Standard Time", 5}},
+		{"no abbreviation", "America/Sao_Paulo", time.Date(2021, 7, 15, 12, 0, 0, 0, time.UTC), TZLabel{"-03", "America/Sao_Paulo", -3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := time.LoadLocation(tt.location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := locationTZLabel(loc, tt.t); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("locationTZLabel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}